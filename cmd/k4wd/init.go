@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tmsmr/k4wd/internal/pkg/scaffold"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// selectCandidates asks the user which of the discovered candidates to add.
+func selectCandidates(candidates []scaffold.Candidate, in io.Reader, out io.Writer) ([]scaffold.Candidate, error) {
+	for i, c := range candidates {
+		fmt.Fprintf(out, "%3d) %s (%s)\n", i+1, c.Name, c)
+	}
+	fmt.Fprint(out, "select forwards to add (e.g. 1,3 or all): ")
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "all" {
+		return candidates, nil
+	}
+	var selected []scaffold.Candidate
+	for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' }) {
+		i, err := strconv.Atoi(field)
+		if err != nil || i < 1 || i > len(candidates) {
+			return nil, fmt.Errorf("invalid selection: %s", field)
+		}
+		selected = append(selected, candidates[i-1])
+	}
+	return selected, nil
+}
+
+// filterCandidates returns the candidates with the given names.
+func filterCandidates(candidates []scaffold.Candidate, names []string) ([]scaffold.Candidate, error) {
+	byName := make(map[string]scaffold.Candidate)
+	for _, c := range candidates {
+		byName[c.Name] = c
+	}
+	var selected []scaffold.Candidate
+	for _, name := range names {
+		c, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("no service or deployment port found for %s", name)
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
+
+func initForwardfile(opts cmdOpts) {
+	if _, err := os.Stat(opts.conf); err == nil && !opts.init.force {
+		log.Fatalf("%s already exists, use -force to overwrite it", opts.conf)
+	}
+
+	kc := newKubeclient(opts)
+	cs, err := kc.Clientset(opts.init.context, nil)
+	must(err)
+	candidates, err := scaffold.Discover(cs, opts.init.namespace)
+	must(err)
+	if len(candidates) == 0 {
+		log.Fatalf("no services or deployments with TCP ports found in namespace %s", opts.init.namespace)
+	}
+
+	var selected []scaffold.Candidate
+	switch {
+	case opts.init.all:
+		selected = candidates
+	case len(opts.init.selection) > 0:
+		selected, err = filterCandidates(candidates, opts.init.selection)
+	default:
+		selected, err = selectCandidates(candidates, os.Stdin, os.Stderr)
+	}
+	must(err)
+	if len(selected) == 0 {
+		log.Fatal("no forwards selected")
+	}
+
+	must(os.WriteFile(opts.conf, scaffold.Render(opts.init.context, selected), 0644))
+	log.Infof("wrote %d forwards to %s", len(selected), opts.conf)
+}
@@ -1,60 +1,171 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/tmsmr/k4wd/internal/pkg/config"
 	"github.com/tmsmr/k4wd/internal/pkg/envfile"
+	"github.com/tmsmr/k4wd/internal/pkg/events"
 	"github.com/tmsmr/k4wd/internal/pkg/forwarder"
 	"github.com/tmsmr/k4wd/internal/pkg/kubeclient"
-	"io"
+	"github.com/tmsmr/k4wd/internal/pkg/metrics"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 )
 
+const attachInterval = 500 * time.Millisecond
+
 func must(err error) {
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(opts cmdOpts) {
-	conf, err := config.Load(config.WithPath(opts.conf))
+func newKubeclient(opts cmdOpts) *kubeclient.Kubeclient {
+	var kcOpts []kubeclient.ClientOption
+	if opts.kubeconf != "" {
+		kcOpts = append(kcOpts, kubeclient.WithKubeconfig(opts.kubeconf))
+	}
+	if opts.inCluster {
+		kcOpts = append(kcOpts, kubeclient.WithInCluster())
+	}
+	kc, err := kubeclient.New(kcOpts...)
 	must(err)
-	log.Debugf("loaded %s containing %d entries", conf.Path, len(conf.Forwards))
+	if kc.InCluster {
+		log.Debugf("created Kubeclient using in-cluster configuration")
+	} else {
+		log.Debugf("created Kubeclient for %s", kc.Kubeconfig)
+	}
+	return kc
+}
 
-	var kc *kubeclient.Kubeclient
-	if opts.kubeconf == "" {
-		kc, err = kubeclient.New()
+// updateEnv writes the environment of the forwards to the Envfile and the export configured in the Forwardfile, if any.
+func updateEnv(ef *envfile.Envfile, conf *config.Forwardfile, fwds map[string]*forwarder.Forwarder) error {
+	if err := ef.Update(fwds); err != nil {
+		return err
+	}
+	if conf.Export == nil {
+		return nil
+	}
+	format, err := envfile.ParseFormat(conf.Export.FormatName())
+	if err != nil {
+		return err
+	}
+	return ef.Export(conf.Export.Path, format)
+}
+
+// cleanupExport removes or blanks the export configured in the Forwardfile, if any.
+func cleanupExport(conf *config.Forwardfile) {
+	if conf.Export == nil {
+		return
+	}
+	var err error
+	if conf.Export.Blank() {
+		log.Debugf("blanking %s", conf.Export.Path)
+		err = envfile.Blank(conf.Export.Path)
 	} else {
-		kc, err = kubeclient.New(kubeclient.WithKubeconfig(opts.kubeconf))
+		log.Debugf("removing %s", conf.Export.Path)
+		err = os.Remove(conf.Export.Path)
 	}
-	must(err)
-	log.Debugf("created Kubeclient for %s", kc.Kubeconfig)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf("failed to clean up %s: %v", conf.Export.Path, err)
+	}
+}
 
+// attach prints the environment of the instance already running the Forwardfile, waiting until it has been written.
+func attach(opts cmdOpts) {
 	ef, err := envfile.New(opts.conf)
 	must(err)
+	for {
+		pid, running, err := ef.Owner()
+		must(err)
+		if !running {
+			log.Fatalf("k4wd is not running for %s", opts.conf)
+		}
+		content, err := ef.Load(opts.format)
+		if err == nil {
+			fmt.Print(string(content))
+			return
+		}
+		log.Debugf("waiting for the environment of pid %d: %v", pid, err)
+		time.Sleep(attachInterval)
+	}
+}
+
+// prepare loads the Forwardfile and takes the lock of its Envfile before probing the fixed local ports,
+// so a second instance reports the running one instead of the ports occupied by it.
+func prepare(path string) (*config.Forwardfile, *envfile.Envfile, error) {
+	conf, err := config.Load(config.WithPath(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	ef, err := envfile.New(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ef.Lock(); err != nil {
+		var le *envfile.LockedError
+		if errors.As(err, &le) {
+			return nil, nil, fmt.Errorf("%w, use -attach to print its environment", err)
+		}
+		return nil, nil, err
+	}
+	if err := conf.ProbeLocal(); err != nil {
+		_ = ef.Unlock()
+		return nil, nil, err
+	}
+	return conf, ef, nil
+}
+
+func run(opts cmdOpts) {
+	conf, ef, err := prepare(opts.conf)
+	must(err)
+	log.Debugf("loaded %s containing %d entries", conf.Path, len(conf.Forwards))
 	log.Debugf("initialized Envfile %s", ef.Path())
+
+	kc := newKubeclient(opts)
 	defer func() {
+		cleanupExport(conf)
 		log.Debugf("removing %s", ef.Path())
 		must(ef.Remove())
+		must(ef.Unlock())
 	}()
 
+	var ev *events.Emitter
+	if opts.output == outputEvents {
+		ev = events.New(os.Stdout)
+	}
+
+	var m *metrics.Metrics
+	if opts.metrics != "" {
+		m = metrics.New()
+		l, err := net.Listen("tcp", opts.metrics)
+		must(err)
+		defer l.Close()
+		log.Infof("serving metrics on http://%s%s", l.Addr(), metrics.Path)
+		go func() {
+			if err := m.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Errorf("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
 	fwds := make(map[string]*forwarder.Forwarder)
 	for name, spec := range conf.Forwards {
-		stdout := io.Discard
-		if opts.debug {
-			stdout = os.Stdout
-		}
-		fwd, err := forwarder.New(name, spec, stdout)
+		fwd, err := forwarder.New(name, spec)
 		must(err)
+		fwd.Events = ev
+		fwd.Metrics = m.Forward(name)
 		fwds[name] = fwd
 	}
 
-	must(ef.Update(fwds))
+	must(updateEnv(ef, conf, fwds))
 
 	log.Infof("starting %d forwards", len(fwds))
 
@@ -66,29 +177,84 @@ func run(opts cmdOpts) {
 	// chan we use to signal the main goroutine to initiate shutdown
 	shutdown := make(chan bool, len(fwds))
 
+	// forwards following rollouts update the environment concurrently when they switch pods
+	var envMu sync.Mutex
+	refreshEnv := func() {
+		envMu.Lock()
+		defer envMu.Unlock()
+		if err := updateEnv(ef, conf, fwds); err != nil {
+			log.Errorf("failed to update environment: %v", err)
+		}
+	}
+
 	for name, fwd := range fwds {
 		failed := make(chan struct{}, 1)
+		finished := make(chan struct{})
 
+		active.Add(1)
 		go func() {
-			active.Add(1)
 			defer active.Done()
+			defer close(finished)
 
+			ev.Emit(events.Event{Type: events.ForwardStarted, Forward: name})
+			fwd.Metrics.SetUp(true)
 			err := fwd.Run(kc, stop)
+			fwd.Metrics.SetUp(false)
+			fwd.Metrics.SetReady(false)
 			if err != nil {
+				ev.Emit(events.Event{Type: events.Failed, Forward: name, Error: err.Error()})
+				fwd.Metrics.Error()
 				if !conf.Relaxed {
-					log.Errorf("%s failed: %v", name, err)
+					fwd.Log.Errorf("%s failed: %v", name, err)
 					shutdown <- true
 				} else {
-					log.Warnf("%s failed: %v", name, err)
+					fwd.Log.Warnf("%s failed: %v", name, err)
+					// waiting for the environment stops instead of timing out
+					ef.Fail(name, err)
+					refreshEnv()
 				}
 				close(failed)
+				return
 			}
+			ev.Emit(events.Event{Type: events.Stopped, Forward: name})
 		}()
 
 		// wait for the forward to either be ready or have failed immediately to enforce sequential startup
 		select {
 		case <-fwd.Ready:
-			log.Infof("%s ready (%s)", fwd.Name, fwd.String())
+			if fwd.WarnUndeclared() {
+				pod, port := fwd.Target()
+				fwd.Log.Warnf("%s forwards to port %d, which is not declared in pod %s", fwd.Name, port, pod)
+				if _, err := fwd.Probe(); err != nil {
+					fwd.Log.Warnf("%s probe failed: %v", fwd.Name, err)
+				}
+			}
+			fwd.Log.Infof("%s ready (%s)", fwd.Name, fwd.Status())
+			fwd.Metrics.SetReady(true)
+			addr, port := fwd.Bind()
+			pod, _ := fwd.Target()
+			ev.Emit(events.Event{
+				Type:    events.Ready,
+				Forward: fwd.Name,
+				Addr:    net.JoinHostPort(addr, strconv.Itoa(int(port))),
+				Pod:     pod,
+			})
+			// the local port and target pod are known once the forward is ready
+			refreshEnv()
+			active.Add(1)
+			go func() {
+				defer active.Done()
+				for {
+					select {
+					case <-fwd.Retargeted:
+						pod, _ := fwd.Target()
+						fwd.Log.Debugf("%s switched to pod %s, updating environment", fwd.Name, pod)
+						refreshEnv()
+					case <-finished:
+						return
+					}
+				}
+			}()
 		case <-failed:
 			break
 		}
@@ -114,22 +280,51 @@ func run(opts cmdOpts) {
 	log.Info("no active forwards left, exiting")
 }
 
+// logFormatter returns the logrus formatter for the -log-format option.
+func logFormatter(format string) log.Formatter {
+	switch format {
+	case logFormatJSON:
+		return &log.JSONFormatter{}
+	case logFormatLogfmt:
+		// without colors, the TextFormatter writes key=value pairs only
+		return &log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		}
+	default:
+		return &log.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: time.TimeOnly,
+		}
+	}
+}
+
 func main() {
 	opts := parseOpts()
 	if opts.debug {
 		log.SetLevel(log.DebugLevel)
 	}
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: time.TimeOnly,
-	})
-	if opts.cmdMode == envMode {
+	log.SetFormatter(logFormatter(opts.logFormat))
+	redirectKlog()
+	switch opts.cmdMode {
+	case envMode:
 		ef, err := envfile.New(opts.conf)
 		must(err)
+		if opts.env.wait > 0 || len(opts.env.forwards) > 0 {
+			must(ef.Wait(opts.env.forwards, opts.env.wait))
+		}
 		content, err := ef.Load(opts.format)
 		must(err)
 		fmt.Print(string(content))
-		return
+	case attachMode:
+		attach(opts)
+	case schemaMode:
+		schema, err := config.Schema()
+		must(err)
+		fmt.Println(string(schema))
+	case initMode:
+		initForwardfile(opts)
+	default:
+		run(opts)
 	}
-	run(opts)
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/envfile"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_prepare(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ffpath := filepath.Join(t.TempDir(), "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(fmt.Sprintf(`
+[forwards.test]
+pod = "test"
+remote = "http"
+local = "%d"
+`, l.Addr().(*net.TCPAddr).Port)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a running instance, the occupied port is reported
+	_, _, err = prepare(ffpath)
+	var le *envfile.LockedError
+	if err == nil || errors.As(err, &le) {
+		t.Errorf("prepare() error = %v, want port probe error", err)
+	}
+
+	// the running instance holds the lock and the port
+	running, err := envfile.New(ffpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := running.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer running.Unlock()
+	_, _, err = prepare(ffpath)
+	if !errors.As(err, &le) || le.PID != os.Getpid() {
+		t.Errorf("prepare() error = %v, want *LockedError for pid %d", err, os.Getpid())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-logr/logr"
+	log "github.com/sirupsen/logrus"
+	"github.com/tmsmr/k4wd/internal/pkg/forwarder"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// logrusSink passes the log of client-go, written with klog, to logrus. Messages are logged at debug level
+// and errors as warnings, like the output of the portforward library.
+type logrusSink struct {
+	entry *log.Entry
+}
+
+func (s logrusSink) Init(logr.RuntimeInfo) {}
+
+func (s logrusSink) Enabled(int) bool {
+	// klog checks its own verbosity before
+	return true
+}
+
+func (s logrusSink) Info(_ int, msg string, kv ...interface{}) {
+	s.withValues(kv).entry.Debug(msg)
+}
+
+func (s logrusSink) Error(err error, msg string, kv ...interface{}) {
+	if err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	s.withValues(kv).entry.Warn(msg)
+}
+
+func (s logrusSink) WithValues(kv ...interface{}) logr.LogSink {
+	return s.withValues(kv)
+}
+
+func (s logrusSink) WithName(name string) logr.LogSink {
+	return logrusSink{entry: s.entry.WithField("logger", name)}
+}
+
+func (s logrusSink) withValues(kv []interface{}) logrusSink {
+	fields := log.Fields{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	return logrusSink{entry: s.entry.WithFields(fields)}
+}
+
+// redirectKlog passes the log and the errors of client-go to logrus, so they are formatted like all other messages
+// instead of being written to stderr by klog.
+func redirectKlog() {
+	klog.SetLogger(logr.New(logrusSink{entry: log.NewEntry(log.StandardLogger())}))
+	// the first handler logs with klog, the others rate limit errors
+	utilruntime.ErrorHandlers[0] = forwarder.HandleError
+}
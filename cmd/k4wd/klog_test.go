@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_redirectKlog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(logFormatter(logFormatJSON))
+	handlers := append([]func(error){}, utilruntime.ErrorHandlers...)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(logFormatter(logFormatText))
+		utilruntime.ErrorHandlers = handlers
+		klog.ClearLogger()
+	})
+	redirectKlog()
+	utilruntime.HandleError(errors.New("an error occurred forwarding 8080 -> 80"))
+	klog.ErrorS(errors.New("connection reset"), "watch failed", "resource", "pods")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("redirectKlog() logged %d lines, want 2: %q", len(lines), buf.String())
+	}
+	want := []map[string]string{
+		{"level": "warning", "msg": "an error occurred forwarding 8080 -> 80"},
+		{"level": "warning", "msg": "watch failed: connection reset", "resource": "pods"},
+	}
+	for i, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("redirectKlog() logged %q, not JSON: %v", line, err)
+		}
+		for k, v := range want[i] {
+			if entry[k] != v {
+				t.Errorf("redirectKlog() logged %s = %v, want %v", k, entry[k], v)
+			}
+		}
+	}
+}
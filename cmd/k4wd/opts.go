@@ -2,7 +2,12 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
 	"github.com/tmsmr/k4wd/internal/pkg/envfile"
+	"os"
+	"strings"
+	"time"
 )
 
 type cmdMode int
@@ -10,43 +15,150 @@ type cmdMode int
 const (
 	runMode cmdMode = iota
 	envMode
+	attachMode
+	schemaMode
+	initMode
 )
 
+type initOpts struct {
+	namespace string
+	context   *string
+	selection []string
+	all       bool
+	force     bool
+}
+
+const (
+	outputText   = "text"
+	outputEvents = "events"
+)
+
+const (
+	logFormatText   = "text"
+	logFormatJSON   = "json"
+	logFormatLogfmt = "logfmt"
+)
+
+type envOpts struct {
+	wait     time.Duration
+	forwards []string
+}
+
 type cmdOpts struct {
 	cmdMode
-	debug    bool
-	conf     string
-	kubeconf string
-	format   envfile.EnvFormat
+	debug     bool
+	conf      string
+	kubeconf  string
+	inCluster bool
+	format    envfile.EnvFormat
+	output    string
+	logFormat string
+	metrics   string
+	init      initOpts
+	env       envOpts
+}
+
+func parseInitOpts(args []string) initOpts {
+	opts := initOpts{}
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.StringVar(&opts.namespace, "n", "default", "namespace to discover services and deployments in")
+	c := fs.String("c", "", "kubeconfig context to use and to set in the Forwardfile")
+	s := fs.String("s", "", "comma-separated forward names to add instead of selecting interactively")
+	fs.BoolVar(&opts.all, "a", false, "add all discovered forwards instead of selecting interactively")
+	fs.BoolVar(&opts.force, "force", false, "overwrite an existing Forwardfile")
+	_ = fs.Parse(args)
+	if *c != "" {
+		opts.context = c
+	}
+	if *s != "" {
+		opts.selection = strings.Split(*s, ",")
+	}
+	return opts
+}
+
+// parseEnvOpts parses the flags of the env subcommand, the output format defaults to the one given with -o.
+func parseEnvOpts(args []string, format string) (envOpts, string) {
+	opts := envOpts{}
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of k4wd env:\n")
+		fmt.Fprintf(fs.Output(), "  k4wd [flags] env [flags] [forward...]   print environment, optionally waiting for all or the given forwards\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	o := fs.String("o", format, fmt.Sprintf("output format for environment (%s)", strings.Join(config.EnvFormats, ", ")))
+	fs.DurationVar(&opts.wait, "wait", 0, "wait up to this long until k4wd runs and the forwards are ready, e.g. 1m")
+	_ = fs.Parse(args)
+	opts.forwards = fs.Args()
+	return opts, *o
 }
 
 func parseOpts() cmdOpts {
 	opts := cmdOpts{}
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of k4wd:\n")
+		fmt.Fprintf(out, "  k4wd [flags]          run the forwards defined in the Forwardfile\n")
+		fmt.Fprintf(out, "  k4wd [flags] env      print environment of the running forwards (see k4wd env -h)\n")
+		fmt.Fprintf(out, "  k4wd [flags] schema   print the JSON Schema of the Forwardfile\n")
+		fmt.Fprintf(out, "  k4wd [flags] init     create a Forwardfile from resources in the cluster (see k4wd init -h)\n")
+		fmt.Fprintf(out, "Flags:\n")
+		flag.PrintDefaults()
+	}
 	e := flag.Bool("e", false, "print environment instead of running k4wd")
-	o := flag.String("o", "env", "output format for environment (env, no-export, json, ps, cmd)")
+	attach := flag.Bool("attach", false, "print environment of the k4wd instance already running the Forwardfile, waiting until it is available")
+	o := flag.String("o", "env", fmt.Sprintf("output format for environment (%s)", strings.Join(config.EnvFormats, ", ")))
+	flag.StringVar(&opts.output, "output", outputText, "output of k4wd while running (text: log only, events: also newline-delimited JSON events on stdout)")
+	flag.StringVar(&opts.metrics, "metrics-addr", "", "address to serve Prometheus metrics of the forwards on at /metrics, e.g. 127.0.0.1:9090")
+	flag.StringVar(&opts.logFormat, "log-format", logFormatText, "format of the log on stderr (text, json, logfmt)")
 	flag.BoolVar(&opts.debug, "d", false, "enable debug logging")
 	flag.StringVar(&opts.conf, "f", "Forwardfile", "path to Forwardfile (context)")
-	flag.StringVar(&opts.kubeconf, "k", "", "alternative path to kubeconfig")
+	flag.StringVar(&opts.kubeconf, "k", "", "path to kubeconfig (default: files listed in KUBECONFIG or ~/.kube/config)")
+	flag.BoolVar(&opts.inCluster, "incluster", false, "use the service account of the pod k4wd runs in (default if no kubeconfig exists)")
 	flag.Parse()
-	if *e {
+	if opts.output != outputText && opts.output != outputEvents {
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid output: %s\n", opts.output)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if opts.logFormat != logFormatText && opts.logFormat != logFormatJSON && opts.logFormat != logFormatLogfmt {
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid log format: %s\n", opts.logFormat)
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch flag.Arg(0) {
+	case "":
+		break
+	case "env":
+		opts.cmdMode = envMode
+		opts.env, *o = parseEnvOpts(flag.Args()[1:], *o)
+	case "schema":
+		opts.cmdMode = schemaMode
+		return opts
+	case "init":
+		opts.cmdMode = initMode
+		opts.init = parseInitOpts(flag.Args()[1:])
+		return opts
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command: %s\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	format, err := envfile.ParseFormat(*o)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid output format: %s\n", *o)
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.format = format
+	switch {
+	case opts.cmdMode == envMode:
+		break
+	case *attach:
+		opts.cmdMode = attachMode
+	case *e:
 		opts.cmdMode = envMode
-		switch *o {
-		case "no-export":
-			opts.format = envfile.FormatNoExport
-			break
-		case "json":
-			opts.format = envfile.FormatJSON
-			break
-		case "ps":
-			opts.format = envfile.FormatPS
-			break
-		case "cmd":
-			opts.format = envfile.FormatCmd
-			break
-		default:
-			opts.format = envfile.FormatDefault
-		}
-	} else {
+	default:
 		opts.cmdMode = runMode
 	}
 	return opts
@@ -1,26 +1,166 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"github.com/BurntSushi/toml"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
+const resolveTimeout = time.Second
+
 type Forwardfile struct {
-	Path     string `toml:"-"`
-	Relaxed  bool
-	Forwards map[string]Forward
+	Path     string             `toml:"-"`
+	Relaxed  bool               `doc:"keep running if a forward fails"`
+	Defaults Forward            `doc:"settings applied to all forwards that do not set them" schema:"inheritable"`
+	Forwards map[string]Forward `doc:"forwards by name, the name is also used for the environment variables" schema:"required"`
+	Export   *Export            `doc:"also write the environment to a file whenever forwards change"`
 }
 
-func (ff *Forwardfile) Validate() error {
-	if ff.Forwards == nil || len(ff.Forwards) == 0 {
-		return fmt.Errorf("no forwards defined")
+// applyDefaults fills unset optional settings of all forwards from the defaults section.
+func (ff *Forwardfile) applyDefaults() {
+	for name, forward := range ff.Forwards {
+		ff.Forwards[name] = forward.withDefaults(ff.Defaults)
+	}
+}
+
+// resolvePaths makes file references of the forwards and the export relative to the directory of the Forwardfile.
+func (ff *Forwardfile) resolvePaths() {
+	dir := filepath.Dir(ff.Path)
+	if ff.Export != nil {
+		ff.Export.resolve(dir)
+	}
+	for name, forward := range ff.Forwards {
+		if forward.Kubeconfig != nil && *forward.Kubeconfig != "" && !filepath.IsAbs(*forward.Kubeconfig) {
+			resolved := filepath.Join(dir, *forward.Kubeconfig)
+			forward.Kubeconfig = &resolved
+			ff.Forwards[name] = forward
+		}
+	}
+}
+
+// names returns the names of all forwards in a stable order.
+func (ff *Forwardfile) names() []string {
+	names := make([]string, 0, len(ff.Forwards))
+	for name := range ff.Forwards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bindIPs returns the IPs a local address may be bound to, resolving hostnames. localhost is always loopback,
+// like the resolver of Go treats it. nil is returned if a hostname can not be resolved.
+func bindIPs(addr string) []net.IP {
+	if ip := net.ParseIP(addr); ip != nil {
+		return []net.IP{ip}
+	}
+	if strings.EqualFold(addr, "localhost") {
+		return []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", addr)
+	if err != nil {
+		return nil
+	}
+	return ips
+}
+
+// bindConflict reports whether two local addresses can not be bound at the same time for the same port.
+func bindConflict(a, b string) bool {
+	ipsa, ipsb := bindIPs(a), bindIPs(b)
+	if ipsa == nil || ipsb == nil {
+		return a == b
 	}
-	for _, forward := range ff.Forwards {
-		if err := forward.Validate(); err != nil {
-			return err
+	for _, ipa := range ipsa {
+		for _, ipb := range ipsb {
+			if ipa.IsUnspecified() || ipb.IsUnspecified() || ipa.Equal(ipb) {
+				return true
+			}
 		}
 	}
-	return nil
+	return false
+}
+
+// conflicts finds forwards with fixed local ports that would collide when binding.
+func (ff *Forwardfile) conflicts() []*ValidationError {
+	type bind struct {
+		name string
+		addr string
+		port int32
+	}
+	var ves []*ValidationError
+	var binds []bind
+	for _, name := range ff.names() {
+		forward := ff.Forwards[name]
+		addr, port, err := forward.BindAddr()
+		if err != nil || port == 0 {
+			continue
+		}
+		for _, other := range binds {
+			if other.port == port && bindConflict(other.addr, addr) {
+				ves = append(ves, &ValidationError{
+					Key: fmt.Sprintf("forwards.%s.local", name),
+					Err: fmt.Errorf("%s:%d conflicts with forwards.%s (%s:%d)", addr, port, other.name, other.addr, other.port),
+				})
+				break
+			}
+		}
+		binds = append(binds, bind{name, addr, port})
+	}
+	return ves
+}
+
+// ProbeLocal checks whether the fixed local addresses of all forwards are currently available on this machine,
+// e.g. after making sure no other instance runs the same Forwardfile.
+func (ff *Forwardfile) ProbeLocal() error {
+	var ves []*ValidationError
+	for _, name := range ff.names() {
+		forward := ff.Forwards[name]
+		if len(forward.validate()) != 0 {
+			continue
+		}
+		if err := forward.probeLocal(); err != nil {
+			ves = append(ves, &ValidationError{Key: fmt.Sprintf("forwards.%s.local", name), Err: err})
+		}
+	}
+	if data, err := os.ReadFile(ff.Path); err == nil {
+		scanPositions(data).locate(ff.Path, ves)
+	}
+	return joinErrors(ves)
+}
+
+// validate collects all problems of the Forwardfile and its forwards, keyed by their TOML key.
+func (ff *Forwardfile) validate() []*ValidationError {
+	ves := validateDefaults(ff.Defaults)
+	if ff.Export != nil {
+		ves = append(ves, prefixKey("export", ff.Export.validate())...)
+	}
+	if len(ff.Forwards) == 0 {
+		return append(ves, &ValidationError{Key: "forwards", Err: fmt.Errorf("no forwards defined")})
+	}
+	for name, forward := range ff.Forwards {
+		var own []*ValidationError
+		for _, ve := range forward.validate() {
+			// reported for the defaults section already
+			if !forward.inherited(ff.Defaults, ve.Key) {
+				own = append(own, ve)
+			}
+		}
+		ves = append(ves, prefixKey("forwards."+name, own)...)
+	}
+	ves = append(ves, ff.conflicts()...)
+	return append(ves, ff.envConflicts()...)
+}
+
+func (ff *Forwardfile) Validate() error {
+	return joinErrors(ff.validate())
 }
 
 type ForwardfileOption func(ff *Forwardfile)
@@ -31,6 +171,22 @@ func WithPath(path string) ForwardfileOption {
 	}
 }
 
+// undecoded returns errors for all keys that are not known to the Forwardfile.
+// Children of unknown keys are omitted, so a misspelled table is only reported once.
+func undecoded(md toml.MetaData) []*ValidationError {
+	unknown := make(map[string]bool)
+	var ves []*ValidationError
+	for _, key := range md.Undecoded() {
+		name := joinKey(key)
+		unknown[name] = true
+		if len(key) > 1 && unknown[joinKey(key[:len(key)-1])] {
+			continue
+		}
+		ves = append(ves, &ValidationError{Key: name, Err: fmt.Errorf("unknown key")})
+	}
+	return ves
+}
+
 func Load(opts ...ForwardfileOption) (*Forwardfile, error) {
 	ff := &Forwardfile{}
 	for _, opt := range opts {
@@ -39,11 +195,19 @@ func Load(opts ...ForwardfileOption) (*Forwardfile, error) {
 	if ff.Path == "" {
 		ff.Path = "Forwardfile"
 	}
-	_, err := toml.DecodeFile(ff.Path, &ff)
+	data, err := os.ReadFile(ff.Path)
+	if err != nil {
+		return nil, err
+	}
+	md, err := toml.Decode(string(data), &ff)
 	if err != nil {
 		return nil, err
 	}
-	if err := ff.Validate(); err != nil {
+	ff.applyDefaults()
+	ff.resolvePaths()
+	ves := append(undecoded(md), ff.validate()...)
+	scanPositions(data).locate(ff.Path, ves)
+	if err := joinErrors(ves); err != nil {
 		return nil, err
 	}
 	return ff, nil
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -20,6 +25,11 @@ func TestForwardfile_Validate(t *testing.T) {
 		{"no forwards", fields{Forwards: nil}, true},
 		{"empty forwards", fields{Forwards: map[string]Forward{}}, true},
 		{"invalid forward", fields{Forwards: map[string]Forward{"test": {Pod: "test"}}}, true},
+		{"distinct ports", fields{Forwards: map[string]Forward{"a": {Pod: "test", Remote: "http", Local: "8080"}, "b": {Pod: "test", Remote: "http", Local: "8081"}}}, false},
+		{"distinct addrs", fields{Forwards: map[string]Forward{"a": {Pod: "test", Remote: "http", Local: "8080"}, "b": {Pod: "test", Remote: "http", Local: "127.0.0.2:8080"}}}, false},
+		{"random ports", fields{Forwards: map[string]Forward{"a": {Pod: "test", Remote: "http"}, "b": {Pod: "test", Remote: "http"}}}, false},
+		{"duplicate port", fields{Forwards: map[string]Forward{"a": {Pod: "test", Remote: "http", Local: "8080"}, "b": {Pod: "test", Remote: "http", Local: "127.0.0.1:8080"}}}, true},
+		{"unspecified addr", fields{Forwards: map[string]Forward{"a": {Pod: "test", Remote: "http", Local: "0.0.0.0:8080"}, "b": {Pod: "test", Remote: "http", Local: "8080"}}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -54,6 +64,13 @@ remote = "http"
 	if err := os.WriteFile(path.Join(dir, "Forwardfile-invalid-fmt"), []byte("{}"), 0644); err != nil {
 		t.Fatal(err)
 	}
+	if err := os.WriteFile(path.Join(dir, "Forwardfile-unknown"), []byte(`
+[forwards.test]
+deploymnet = "test"
+remote = "http"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
 	type args struct {
 		opts []ForwardfileOption
 	}
@@ -66,6 +83,7 @@ remote = "http"
 		{"valid", args{}, &Forwardfile{Path: "Forwardfile", Relaxed: false, Forwards: map[string]Forward{"test": {Pod: "test", Remote: "http"}}}, false},
 		{"invalid", args{[]ForwardfileOption{WithPath("Forwardfile-invalid")}}, nil, true},
 		{"invalid fmt", args{[]ForwardfileOption{WithPath("Forwardfile-invalid-fmt")}}, nil, true},
+		{"unknown key", args{[]ForwardfileOption{WithPath("Forwardfile-unknown")}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -80,3 +98,242 @@ remote = "http"
 		})
 	}
 }
+
+func TestLoad_ValidationErrors(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(`relaxd = true
+
+[forwards.a]
+deploymnet = "test"
+remote = "http"
+
+[forwards.b]
+pod = "test"
+local = "NaN"
+
+[forward.c]
+pod = "test"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(WithPath(ffpath))
+	if err == nil {
+		t.Fatal("Load() error = nil, want validation errors")
+	}
+	want := []string{
+		ffpath + ":1:1: relaxd: unknown key",
+		ffpath + ":3:1: forwards.a: exactly one of pod, deployment or service must be specified",
+		ffpath + ":4:1: forwards.a.deploymnet: unknown key",
+		ffpath + ":7:1: forwards.b.remote: remote (named) port must be specified",
+		ffpath + ":9:1: forwards.b.local: strconv.Atoi: parsing \"NaN\": invalid syntax",
+		ffpath + ":11:1: forward.c: unknown key",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() error = %q, want %q", got, want)
+	}
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Errorf("Load() error is not a *ValidationError")
+	}
+}
+
+func Test_bindConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{"same", "127.0.0.1", "127.0.0.1", true},
+		{"different", "127.0.0.1", "127.0.0.2", false},
+		{"unspecified", "0.0.0.0", "127.0.0.1", true},
+		{"unspecified v6", "127.0.0.1", "::", true},
+		{"same hostname", "localhost", "localhost", true},
+		{"localhost", "localhost", "127.0.0.1", true},
+		{"localhost v6", "::1", "localhost", true},
+		{"localhost and other loopback", "localhost", "127.0.0.2", false},
+		{"unresolvable hostname", "k4wd.invalid", "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bindConflict(tt.a, tt.b); got != tt.want {
+				t.Errorf("bindConflict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardfile_ProbeLocal(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(fmt.Sprintf(`
+[forwards.test]
+pod = "test"
+remote = "http"
+local = "%d"
+`, l.Addr().(*net.TCPAddr).Port)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := Load(WithPath(ffpath))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = ff.ProbeLocal()
+	if err == nil {
+		t.Fatalf("ProbeLocal() error = nil, want error")
+	}
+	if !strings.Contains(err.Error(), "forwards.test.local") {
+		t.Errorf("ProbeLocal() error = %v, want key of the forward", err)
+	}
+	l.Close()
+	if err := ff.ProbeLocal(); err != nil {
+		t.Errorf("ProbeLocal() error = %v after the port was released", err)
+	}
+}
+
+func TestLoad_ResolvePaths(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(`
+[forwards.relative]
+pod = "test"
+remote = "http"
+kubeconfig = "kube/config"
+
+[forwards.absolute]
+pod = "test"
+remote = "http"
+kubeconfig = "/etc/kube/config"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := Load(WithPath(ffpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *ff.Forwards["relative"].Kubeconfig, filepath.Join(dir, "kube", "config"); got != want {
+		t.Errorf("Load() kubeconfig = %v, want %v", got, want)
+	}
+	if got, want := *ff.Forwards["absolute"].Kubeconfig, "/etc/kube/config"; got != want {
+		t.Errorf("Load() kubeconfig = %v, want %v", got, want)
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(`
+[defaults]
+namespace = "k4wd"
+proxy_url = "http://proxy:3128"
+kubeconfig = "kube/config"
+
+[forwards.inherit]
+pod = "test"
+remote = "http"
+
+[forwards.override]
+pod = "test"
+remote = "http"
+namespace = "other"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := Load(WithPath(ffpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *ff.Forwards["inherit"].Namespace; got != "k4wd" {
+		t.Errorf("Load() namespace = %v, want k4wd", got)
+	}
+	if got := *ff.Forwards["override"].Namespace; got != "other" {
+		t.Errorf("Load() namespace = %v, want other", got)
+	}
+	if got := *ff.Forwards["override"].ProxyURL; got != "http://proxy:3128" {
+		t.Errorf("Load() proxy_url = %v, want http://proxy:3128", got)
+	}
+	if got, want := *ff.Forwards["inherit"].Kubeconfig, filepath.Join(dir, "kube", "config"); got != want {
+		t.Errorf("Load() kubeconfig = %v, want %v", got, want)
+	}
+}
+
+func TestLoad_InvalidDefaults(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(`[defaults]
+transport = "http3"
+
+[forwards.a]
+pod = "test"
+remote = "http"
+
+[forwards.b]
+pod = "test"
+remote = "http"
+transport = "quic"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(WithPath(ffpath))
+	if err == nil {
+		t.Fatal("Load() error = nil, want validation errors")
+	}
+	want := []string{
+		ffpath + ":2:1: defaults.transport: must be one of auto, spdy, websocket",
+		ffpath + ":11:1: forwards.b.transport: must be one of auto, spdy, websocket",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() error = %q, want %q", got, want)
+	}
+}
+
+func TestLoad_BalancePod(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(`[defaults]
+balance = "least-conn"
+
+[forwards.inherit]
+pod = "test"
+remote = "http"
+
+[forwards.explicit]
+pod = "test"
+remote = "http"
+balance = "random"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(WithPath(ffpath))
+	if err == nil {
+		t.Fatal("Load() error = nil, want validation errors")
+	}
+	want := []string{ffpath + ":11:1: forwards.explicit.balance: only applies to deployments and services"}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() error = %q, want %q", got, want)
+	}
+}
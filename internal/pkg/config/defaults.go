@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// inheritable reports whether a Forward field is an optional setting that can be set in the defaults section.
+// Optional settings are pointers or lists, so unset values can be told apart from explicitly set ones.
+func inheritable(field reflect.StructField) bool {
+	if parseSchemaTag(field.Tag.Get("schema")).perForward {
+		return false
+	}
+	switch field.Type.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		return true
+	default:
+		return false
+	}
+}
+
+// withDefaults returns a copy of f with all unset optional settings taken from d.
+func (f Forward) withDefaults(d Forward) Forward {
+	fv := reflect.ValueOf(&f).Elem()
+	dv := reflect.ValueOf(d)
+	for i := 0; i < fv.NumField(); i++ {
+		if !inheritable(fv.Type().Field(i)) {
+			continue
+		}
+		if fv.Field(i).IsNil() && !dv.Field(i).IsNil() {
+			fv.Field(i).Set(dv.Field(i))
+		}
+	}
+	return f
+}
+
+// fieldByKey returns the Forward field decoded from the TOML key.
+func fieldByKey(key string) (reflect.StructField, bool) {
+	t := reflect.TypeOf(Forward{})
+	for i := 0; i < t.NumField(); i++ {
+		if tomlKey(t.Field(i)) == key {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// inherited reports whether the setting with the TOML key was taken from d by withDefaults instead of being set in f.
+// withDefaults copies the pointers, so inherited settings share their value with d.
+func (f Forward) inherited(d Forward, key string) bool {
+	field, ok := fieldByKey(key)
+	if !ok || !inheritable(field) {
+		return false
+	}
+	fv := reflect.ValueOf(f).FieldByIndex(field.Index)
+	dv := reflect.ValueOf(d).FieldByIndex(field.Index)
+	return !fv.IsNil() && !dv.IsNil() && fv.Pointer() == dv.Pointer()
+}
+
+// validateDefaults ensures the defaults section only contains valid optional settings.
+// Problems are reported once for the defaults section, forwards inheriting the settings skip them.
+func validateDefaults(d Forward) []*ValidationError {
+	var ves []*ValidationError
+	dv := reflect.ValueOf(d)
+	for i := 0; i < dv.NumField(); i++ {
+		field := dv.Type().Field(i)
+		if inheritable(field) || dv.Field(i).IsZero() {
+			continue
+		}
+		ves = append(ves, &ValidationError{
+			Key: fmt.Sprintf("defaults.%s", tomlKey(field)),
+			Err: fmt.Errorf("can only be set per forward"),
+		})
+	}
+	var settings []*ValidationError
+	for _, ve := range d.validate() {
+		if field, ok := fieldByKey(ve.Key); ok && inheritable(field) {
+			settings = append(settings, ve)
+		}
+	}
+	return append(ves, prefixKey("defaults", settings)...)
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForward_withDefaults(t *testing.T) {
+	str := func(s string) *string { return &s }
+	insecure := true
+	defaults := Forward{Namespace: str("k4wd"), ProxyURL: str("http://proxy:3128"), AsGroups: []string{"readonly"}, InsecureSkipTLSVerify: &insecure}
+	tests := []struct {
+		name string
+		f    Forward
+		want Forward
+	}{
+		{"inherit", Forward{Pod: "pod", Remote: "http"}, Forward{Pod: "pod", Remote: "http", Namespace: str("k4wd"), ProxyURL: str("http://proxy:3128"), AsGroups: []string{"readonly"}, InsecureSkipTLSVerify: &insecure}},
+		{"override", Forward{Pod: "pod", Remote: "http", Namespace: str("other"), AsGroups: []string{}}, Forward{Pod: "pod", Remote: "http", Namespace: str("other"), ProxyURL: str("http://proxy:3128"), AsGroups: []string{}, InsecureSkipTLSVerify: &insecure}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f.withDefaults(defaults); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withDefaults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateDefaults(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		defaults Forward
+		wantKeys []string
+	}{
+		{"empty", Forward{}, nil},
+		{"settings", Forward{Context: str("local"), Server: str("https://bastion:6443")}, nil},
+		{"targets", Forward{Pod: "pod", Remote: "http"}, []string{"defaults.pod", "defaults.remote"}},
+		{"per-forward settings", Forward{Env: map[string]string{"URL": "http://{{.Addr}}"}}, []string{"defaults.env"}},
+		{"invalid settings", Forward{QPS: func() *float32 { f := float32(0); return &f }(), Transport: str("http3")}, []string{"defaults.qps", "defaults.transport"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []string
+			for _, ve := range validateDefaults(tt.defaults) {
+				keys = append(keys, ve.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("validateDefaults() keys = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
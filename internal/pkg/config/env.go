@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Suffixes of the variables exported for every forward.
+const (
+	EnvSuffixAddr = "ADDR"
+	EnvSuffixHost = "HOST"
+	EnvSuffixPort = "PORT"
+)
+
+// EnvData is the data the env templates of a forward are rendered with, e.g. {{.Host}}:{{.Port}}.
+type EnvData struct {
+	Name      string
+	Host      string
+	Port      int32
+	Addr      string
+	Pod       string
+	Namespace string
+	Context   string
+}
+
+var (
+	envNameChars = regexp.MustCompile(`\W`)
+	envVarName   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// EnvPrefix returns the prefix of the variables exported for a forward, e.g. NGINX_SERVICE for nginx-service.
+func EnvPrefix(name string) string {
+	return strings.ToUpper(envNameChars.ReplaceAllString(name, "_"))
+}
+
+// envNames returns the names of all variables exported for the forward, sorted.
+func (f *Forward) envNames(name string) []string {
+	prefix := EnvPrefix(name)
+	names := []string{prefix + "_" + EnvSuffixAddr, prefix + "_" + EnvSuffixHost, prefix + "_" + EnvSuffixPort}
+	for key := range f.Env {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseEnvTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// RenderEnv renders the env templates of the forward.
+func (f *Forward) RenderEnv(data EnvData) (map[string]string, error) {
+	env := make(map[string]string, len(f.Env))
+	for name, text := range f.Env {
+		tmpl, err := parseEnvTemplate(name, text)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		env[name] = buf.String()
+	}
+	return env, nil
+}
+
+// validateEnv checks the names of the env variables and renders the templates with sample data to find unknown fields.
+func (f *Forward) validateEnv() []*ValidationError {
+	var ves []*ValidationError
+	names := make([]string, 0, len(f.Env))
+	for name := range f.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sample := EnvData{Name: "name", Host: DefaultBindAddr, Port: 8080, Addr: DefaultBindAddr + ":8080", Pod: "pod", Namespace: "default"}
+	for _, name := range names {
+		key := fmt.Sprintf("env.%s", name)
+		if !envVarName.MatchString(name) {
+			ves = append(ves, &ValidationError{Key: key, Err: fmt.Errorf("invalid variable name")})
+			continue
+		}
+		tmpl, err := parseEnvTemplate(name, f.Env[name])
+		if err == nil {
+			err = tmpl.Execute(&bytes.Buffer{}, sample)
+		}
+		if err != nil {
+			ves = append(ves, &ValidationError{Key: key, Err: err})
+		}
+	}
+	return ves
+}
+
+// envConflicts finds variables that would be exported by several forwards.
+func (ff *Forwardfile) envConflicts() []*ValidationError {
+	var ves []*ValidationError
+	exported := make(map[string]string)
+	for _, name := range ff.names() {
+		forward := ff.Forwards[name]
+		for _, env := range forward.envNames(name) {
+			if other, ok := exported[env]; ok {
+				key := fmt.Sprintf("forwards.%s", name)
+				if _, custom := forward.Env[env]; custom {
+					key = fmt.Sprintf("forwards.%s.env.%s", name, env)
+				}
+				ves = append(ves, &ValidationError{Key: key, Err: fmt.Errorf("variable %s is already exported by forwards.%s", env, other)})
+				continue
+			}
+			exported[env] = name
+		}
+	}
+	return ves
+}
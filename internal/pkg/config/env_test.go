@@ -0,0 +1,89 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"nginx", "NGINX"},
+		{"nginx-service", "NGINX_SERVICE"},
+		{"db.primary", "DB_PRIMARY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EnvPrefix(tt.name); got != tt.want {
+				t.Errorf("EnvPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForward_RenderEnv(t *testing.T) {
+	f := Forward{Env: map[string]string{
+		"API_URL": "http://{{.Addr}}/api",
+		"TARGET":  "{{.Namespace}}/{{.Pod}}",
+	}}
+	got, err := f.RenderEnv(EnvData{Host: "127.0.0.1", Port: 8080, Addr: "127.0.0.1:8080", Pod: "api-0", Namespace: "k4wd"})
+	if err != nil {
+		t.Fatalf("RenderEnv() error = %v", err)
+	}
+	want := map[string]string{"API_URL": "http://127.0.0.1:8080/api", "TARGET": "k4wd/api-0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RenderEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestForward_validateEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantKeys []string
+	}{
+		{"valid", map[string]string{"URL": "http://{{.Host}}:{{.Port}}", "CONST": "value"}, nil},
+		{"invalid name", map[string]string{"1URL": "x", "MY-URL": "x"}, []string{"env.1URL", "env.MY-URL"}},
+		{"invalid template", map[string]string{"URL": "{{.Host"}, []string{"env.URL"}},
+		{"unknown field", map[string]string{"URL": "{{.Hostname}}"}, []string{"env.URL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Forward{Env: tt.env}
+			var keys []string
+			for _, ve := range f.validateEnv() {
+				keys = append(keys, ve.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("validateEnv() keys = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestForwardfile_envConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		forwards map[string]Forward
+		wantKeys []string
+	}{
+		{"none", map[string]Forward{"a": {Env: map[string]string{"URL": "x"}}, "b": {}}, nil},
+		{"custom", map[string]Forward{"a": {Env: map[string]string{"URL": "x"}}, "b": {Env: map[string]string{"URL": "y"}}}, []string{"forwards.b.env.URL"}},
+		{"custom and exported", map[string]Forward{"a": {}, "b": {Env: map[string]string{"A_PORT": "y"}}}, []string{"forwards.b.env.A_PORT"}},
+		{"similar names", map[string]Forward{"a-b": {}, "a_b": {}}, []string{"forwards.a_b", "forwards.a_b", "forwards.a_b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ff := &Forwardfile{Forwards: tt.forwards}
+			var keys []string
+			for _, ve := range ff.envConflicts() {
+				keys = append(keys, ve.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("envConflicts() keys = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
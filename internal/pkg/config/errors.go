@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError describes a single problem with a key in a Forwardfile.
+type ValidationError struct {
+	File   string
+	Line   int
+	Column int
+	Key    string
+	Err    error
+}
+
+func (ve *ValidationError) Error() string {
+	var loc []string
+	if ve.File != "" {
+		loc = append(loc, ve.File)
+	}
+	if ve.Line > 0 {
+		loc = append(loc, strconv.Itoa(ve.Line), strconv.Itoa(ve.Column))
+	}
+	msg := ve.Err.Error()
+	if ve.Key != "" {
+		msg = fmt.Sprintf("%s: %s", ve.Key, msg)
+	}
+	if len(loc) > 0 {
+		msg = fmt.Sprintf("%s: %s", strings.Join(loc, ":"), msg)
+	}
+	return msg
+}
+
+func (ve *ValidationError) Unwrap() error {
+	return ve.Err
+}
+
+// prefixKey returns a copy of ves with prefix prepended to every key.
+func prefixKey(prefix string, ves []*ValidationError) []*ValidationError {
+	res := make([]*ValidationError, 0, len(ves))
+	for _, ve := range ves {
+		key := prefix
+		if ve.Key != "" {
+			key = fmt.Sprintf("%s.%s", prefix, ve.Key)
+		}
+		res = append(res, &ValidationError{File: ve.File, Line: ve.Line, Column: ve.Column, Key: key, Err: ve.Err})
+	}
+	return res
+}
+
+// joinErrors sorts ves by position and key and joins them into a single error.
+func joinErrors(ves []*ValidationError) error {
+	if len(ves) == 0 {
+		return nil
+	}
+	sort.SliceStable(ves, func(i, j int) bool {
+		if ves[i].Line != ves[j].Line {
+			return ves[i].Line < ves[j].Line
+		}
+		if ves[i].Column != ves[j].Column {
+			return ves[i].Column < ves[j].Column
+		}
+		return ves[i].Key < ves[j].Key
+	})
+	errs := make([]error, 0, len(ves))
+	for _, ve := range ves {
+		errs = append(errs, ve)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidationError_Error(t *testing.T) {
+	err := fmt.Errorf("unknown key")
+	tests := []struct {
+		name string
+		ve   *ValidationError
+		want string
+	}{
+		{"plain", &ValidationError{Err: err}, "unknown key"},
+		{"key", &ValidationError{Key: "forwards.test", Err: err}, "forwards.test: unknown key"},
+		{"file", &ValidationError{File: "Forwardfile", Key: "forwards.test", Err: err}, "Forwardfile: forwards.test: unknown key"},
+		{"position without file", &ValidationError{Line: 3, Column: 1, Key: "forwards.test", Err: err}, "3:1: forwards.test: unknown key"},
+		{"position", &ValidationError{File: "Forwardfile", Line: 3, Column: 1, Key: "forwards.test", Err: err}, "Forwardfile:3:1: forwards.test: unknown key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ve.Error(); got != tt.want {
+				t.Errorf("Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_joinErrors(t *testing.T) {
+	if err := joinErrors(nil); err != nil {
+		t.Errorf("joinErrors() error = %v, want nil", err)
+	}
+	err := joinErrors([]*ValidationError{
+		{Line: 5, Column: 1, Key: "b", Err: fmt.Errorf("second")},
+		{Line: 2, Column: 1, Key: "a", Err: fmt.Errorf("first")},
+	})
+	if want := "2:1: a: first\n5:1: b: second"; err == nil || err.Error() != want {
+		t.Errorf("joinErrors() error = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Names of the formats the environment can be exported in, see k4wd -e -o.
+var EnvFormats = []string{"env", "no-export", "json", "ps", "cmd", "dotenv", "direnv", "fish", "nu", "yaml", "docker"}
+
+const (
+	DefaultExportFormat = "dotenv"
+
+	ExportOnExitRemove = "remove"
+	ExportOnExitBlank  = "blank"
+)
+
+// Export configures a file the environment is written to whenever forwards change, e.g. for IDE run configurations
+// or docker compose.
+type Export struct {
+	Path   string  `doc:"file to write, relative to the Forwardfile, e.g. .env.k4wd" schema:"required"`
+	Format *string `doc:"format of the file (default: dotenv)" schema:"enum=env|no-export|json|ps|cmd|dotenv|direnv|fish|nu|yaml|docker"`
+	OnExit *string `toml:"on_exit" doc:"remove the file or leave it empty when k4wd exits (default: remove)" schema:"enum=remove|blank"`
+}
+
+// FormatName returns the configured format or the default.
+func (e *Export) FormatName() string {
+	if e.Format == nil {
+		return DefaultExportFormat
+	}
+	return *e.Format
+}
+
+// Blank reports whether the file is emptied instead of removed on exit.
+func (e *Export) Blank() bool {
+	return e.OnExit != nil && *e.OnExit == ExportOnExitBlank
+}
+
+// resolve makes the path relative to the directory of the Forwardfile.
+func (e *Export) resolve(dir string) {
+	if e.Path != "" && !filepath.IsAbs(e.Path) {
+		e.Path = filepath.Join(dir, e.Path)
+	}
+}
+
+// validate collects all problems of the export settings, keyed by their TOML key relative to the export table.
+func (e *Export) validate() []*ValidationError {
+	var ves []*ValidationError
+	if e.Path == "" {
+		ves = append(ves, &ValidationError{Key: "path", Err: fmt.Errorf("path must be specified")})
+	}
+	if err := oneOf(e.Format, EnvFormats...); err != nil {
+		ves = append(ves, &ValidationError{Key: "format", Err: err})
+	}
+	if err := oneOf(e.OnExit, ExportOnExitRemove, ExportOnExitBlank); err != nil {
+		ves = append(ves, &ValidationError{Key: "on_exit", Err: err})
+	}
+	return ves
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExport_validate(t *testing.T) {
+	format, invalidFormat, blank, invalidOnExit := "docker", "xml", ExportOnExitBlank, "keep"
+	tests := []struct {
+		name     string
+		export   Export
+		wantKeys []string
+	}{
+		{"minimal", Export{Path: ".env.k4wd"}, nil},
+		{"complete", Export{Path: ".env.k4wd", Format: &format, OnExit: &blank}, nil},
+		{"no path", Export{}, []string{"path"}},
+		{"invalid", Export{Path: ".env.k4wd", Format: &invalidFormat, OnExit: &invalidOnExit}, []string{"format", "on_exit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []string
+			for _, ve := range tt.export.validate() {
+				keys = append(keys, ve.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("validate() keys = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+// the schema tag can not refer to EnvFormats, so it is kept in sync here
+func TestExport_formatEnum(t *testing.T) {
+	field, _ := reflect.TypeOf(Export{}).FieldByName("Format")
+	if got := parseSchemaTag(field.Tag.Get("schema")).enum; !reflect.DeepEqual(got, EnvFormats) {
+		t.Errorf("schema enum of format = %v, want EnvFormats %v", got, EnvFormats)
+	}
+}
+
+func TestLoad_Export(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, []byte(`
+[export]
+path = ".env.k4wd"
+on_exit = "blank"
+
+[forwards.test]
+pod = "test"
+remote = "http"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := Load(WithPath(ffpath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ff.Export.Path, filepath.Join(dir, ".env.k4wd"); got != want {
+		t.Errorf("Load() export path = %v, want %v", got, want)
+	}
+	if got := ff.Export.FormatName(); got != DefaultExportFormat {
+		t.Errorf("FormatName() = %v, want %v", got, DefaultExportFormat)
+	}
+	if !ff.Export.Blank() {
+		t.Errorf("Blank() = false, want true")
+	}
+}
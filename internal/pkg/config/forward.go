@@ -2,8 +2,34 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
+	"time"
+)
+
+// DefaultBindAddr is the local address forwards bind to if no address is specified.
+const DefaultBindAddr = "127.0.0.1"
+
+// Transports for port-forward connections.
+const (
+	TransportAuto      = "auto"
+	TransportSPDY      = "spdy"
+	TransportWebSocket = "websocket"
+)
+
+// Policies for remote ports that are not declared in the pod spec.
+const (
+	UndeclaredStrict = "strict"
+	UndeclaredWarn   = "warn"
+	UndeclaredAllow  = "allow"
+)
+
+// Strategies for distributing connections across several pods.
+const (
+	BalanceRoundRobin = "round-robin"
+	BalanceLeastConn  = "least-conn"
+	BalanceRandom     = "random"
 )
 
 type ForwardType int
@@ -15,13 +41,36 @@ const (
 )
 
 type Forward struct {
-	Context    *string
-	Namespace  *string
-	Pod        string
-	Deployment string
-	Service    string
-	Remote     string
-	Local      string
+	Context    *string `doc:"kubeconfig context to use, defaults to the current context"`
+	Namespace  *string `doc:"namespace of the target, defaults to default"`
+	Pod        string  `doc:"name of the target pod" schema:"oneof=target"`
+	Deployment string  `doc:"name of the target deployment" schema:"oneof=target"`
+	Service    string  `doc:"name of the target service" schema:"oneof=target"`
+	Remote     string  `doc:"remote port, either a number or a port name" schema:"required"`
+	Local      string  `doc:"local port or address:port, a random port is used if omitted" schema:"pattern=^(.+:)?[0-9]+$"`
+
+	Container  *string        `doc:"container the remote port is looked up in, for pods with several containers (e.g. sidecars)"`
+	Wait       *time.Duration `doc:"wait up to this long for the target to become ready instead of failing if it is not, e.g. 2m"`
+	Balance    *string        `doc:"distribute connections across all ready pods of a deployment or service: round-robin, least-conn or random, instead of forwarding to a single pod" schema:"enum=round-robin|least-conn|random"`
+	Undeclared *string        `doc:"policy for numeric remote ports not declared in the pod spec: strict fails, warn logs a warning and probes the port, allow forwards silently (default: warn)" schema:"enum=strict|warn|allow"`
+
+	Kubeconfig *string  `doc:"path to a kubeconfig file used instead of the global one, relative to the Forwardfile"`
+	As         *string  `doc:"user to impersonate"`
+	AsGroups   []string `toml:"as_groups" doc:"groups to impersonate, requires as"`
+
+	Server                *string `doc:"API server URL overriding the one of the context"`
+	ProxyURL              *string `toml:"proxy_url" doc:"URL of the proxy used to connect to the API server"`
+	TLSServerName         *string `toml:"tls_server_name" doc:"server name used to verify the certificate of the API server"`
+	InsecureSkipTLSVerify *bool   `toml:"insecure_skip_tls_verify" doc:"do not verify the certificate of the API server"`
+
+	QPS         *float32       `toml:"qps" doc:"maximum queries per second to the API server (client-go default: 5)"`
+	Burst       *int           `doc:"maximum burst of queries to the API server (client-go default: 10)"`
+	Timeout     *time.Duration `doc:"timeout for requests to the API server, except for watches, e.g. 30s"`
+	DialTimeout *time.Duration `toml:"dial_timeout" doc:"timeout for connecting to the API server, including port-forward connections"`
+
+	Transport *string `doc:"port-forward protocol, auto uses WebSockets and falls back to SPDY if unsupported (default: auto)" schema:"enum=auto|spdy|websocket"`
+
+	Env map[string]string `doc:"additional variables exported for the forward, Go templates with .Name, .Host, .Port, .Addr, .Pod, .Namespace and .Context, e.g. http://{{.Addr}}" schema:"perforward"`
 }
 
 func (f *Forward) Type() ForwardType {
@@ -65,7 +114,47 @@ func (f *Forward) LocalAddr() (string, int32, error) {
 	return addr, int32(port), nil
 }
 
-func (f *Forward) Validate() error {
+// BindAddr returns the local address and port the forward binds to, applying DefaultBindAddr.
+func (f *Forward) BindAddr() (string, int32, error) {
+	addr, port, err := f.LocalAddr()
+	if err != nil {
+		return "", 0, err
+	}
+	if addr == "" {
+		addr = DefaultBindAddr
+	}
+	return addr, port, nil
+}
+
+// probeLocal checks whether the fixed local address of the forward is currently available.
+func (f *Forward) probeLocal() error {
+	addr, port, err := f.BindAddr()
+	if err != nil || port == 0 {
+		return err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(int(port))))
+	if err != nil {
+		return fmt.Errorf("local address not available: %v", err)
+	}
+	return l.Close()
+}
+
+// oneOf checks that an optional setting is either unset or one of the allowed values.
+func oneOf(val *string, allowed ...string) error {
+	if val == nil {
+		return nil
+	}
+	for _, a := range allowed {
+		if *val == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+}
+
+// validate collects all problems of the forward, keyed by their TOML key relative to the forward.
+func (f *Forward) validate() []*ValidationError {
+	var ves []*ValidationError
 	resources := 0
 	for _, s := range []string{f.Pod, f.Deployment, f.Service} {
 		if s != "" {
@@ -73,14 +162,52 @@ func (f *Forward) Validate() error {
 		}
 	}
 	if resources != 1 {
-		return fmt.Errorf("exactly one of pod, deployment or service must be specified")
+		ves = append(ves, &ValidationError{Err: fmt.Errorf("exactly one of pod, deployment or service must be specified")})
 	}
 	if f.Remote == "" {
-		return fmt.Errorf("remote (named) port must be specified")
+		ves = append(ves, &ValidationError{Key: "remote", Err: fmt.Errorf("remote (named) port must be specified")})
 	}
 	_, _, err := f.LocalAddr()
 	if err != nil {
-		return err
+		ves = append(ves, &ValidationError{Key: "local", Err: err})
+	}
+	if f.Container != nil && *f.Container == "" {
+		ves = append(ves, &ValidationError{Key: "container", Err: fmt.Errorf("must not be empty")})
+	}
+	if f.Wait != nil && *f.Wait < 0 {
+		ves = append(ves, &ValidationError{Key: "wait", Err: fmt.Errorf("must not be negative")})
 	}
-	return nil
+	if err := oneOf(f.Balance, BalanceRoundRobin, BalanceLeastConn, BalanceRandom); err != nil {
+		ves = append(ves, &ValidationError{Key: "balance", Err: err})
+	}
+	if f.Balance != nil && f.Pod != "" {
+		ves = append(ves, &ValidationError{Key: "balance", Err: fmt.Errorf("only applies to deployments and services")})
+	}
+	if err := oneOf(f.Undeclared, UndeclaredStrict, UndeclaredWarn, UndeclaredAllow); err != nil {
+		ves = append(ves, &ValidationError{Key: "undeclared", Err: err})
+	}
+	if len(f.AsGroups) > 0 && (f.As == nil || *f.As == "") {
+		ves = append(ves, &ValidationError{Key: "as_groups", Err: fmt.Errorf("impersonating groups requires a user (as)")})
+	}
+	if f.QPS != nil && *f.QPS <= 0 {
+		ves = append(ves, &ValidationError{Key: "qps", Err: fmt.Errorf("must be greater than 0")})
+	}
+	if f.Burst != nil && *f.Burst <= 0 {
+		ves = append(ves, &ValidationError{Key: "burst", Err: fmt.Errorf("must be greater than 0")})
+	}
+	if f.Timeout != nil && *f.Timeout < 0 {
+		ves = append(ves, &ValidationError{Key: "timeout", Err: fmt.Errorf("must not be negative")})
+	}
+	if f.DialTimeout != nil && *f.DialTimeout < 0 {
+		ves = append(ves, &ValidationError{Key: "dial_timeout", Err: fmt.Errorf("must not be negative")})
+	}
+	if err := oneOf(f.Transport, TransportAuto, TransportSPDY, TransportWebSocket); err != nil {
+		ves = append(ves, &ValidationError{Key: "transport", Err: err})
+	}
+	ves = append(ves, f.validateEnv()...)
+	return ves
+}
+
+func (f *Forward) Validate() error {
+	return joinErrors(f.validate())
 }
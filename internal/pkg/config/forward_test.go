@@ -1,6 +1,9 @@
 package config
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestForward_Type(t *testing.T) {
 	type fields struct {
@@ -77,6 +80,16 @@ func TestForward_Validate(t *testing.T) {
 		Service    string
 		Remote     string
 		Local      string
+		Container  *string
+		Undeclared *string
+		Wait       *time.Duration
+		Balance    *string
+		As         *string
+		AsGroups   []string
+		QPS        *float32
+		Burst      *int
+		Timeout    *time.Duration
+		Transport  *string
 	}
 	tests := []struct {
 		name    string
@@ -88,6 +101,23 @@ func TestForward_Validate(t *testing.T) {
 		{"missing res", fields{Remote: "http", Local: ""}, true},
 		{"missing remote", fields{Pod: "pod", Local: ""}, true},
 		{"invalid local", fields{Pod: "pod", Remote: "http", Local: "NaN"}, true},
+		{"container", fields{Pod: "pod", Remote: "http", Container: func() *string { s := "app"; return &s }()}, false},
+		{"empty container", fields{Pod: "pod", Remote: "http", Container: func() *string { s := ""; return &s }()}, true},
+		{"undeclared policy", fields{Pod: "pod", Remote: "9090", Undeclared: func() *string { s := UndeclaredStrict; return &s }()}, false},
+		{"invalid undeclared policy", fields{Pod: "pod", Remote: "9090", Undeclared: func() *string { s := "ignore"; return &s }()}, true},
+		{"wait", fields{Pod: "pod", Remote: "http", Wait: func() *time.Duration { d := time.Minute; return &d }()}, false},
+		{"negative wait", fields{Pod: "pod", Remote: "http", Wait: func() *time.Duration { d := -time.Minute; return &d }()}, true},
+		{"balance", fields{Service: "svc", Remote: "http", Balance: func() *string { s := BalanceLeastConn; return &s }()}, false},
+		{"invalid balance", fields{Service: "svc", Remote: "http", Balance: func() *string { s := "weighted"; return &s }()}, true},
+		{"balance pod", fields{Pod: "pod", Remote: "http", Balance: func() *string { s := BalanceRoundRobin; return &s }()}, true},
+		{"impersonation", fields{Pod: "pod", Remote: "http", As: func() *string { s := "user"; return &s }(), AsGroups: []string{"group"}}, false},
+		{"groups without user", fields{Pod: "pod", Remote: "http", AsGroups: []string{"group"}}, true},
+		{"client settings", fields{Pod: "pod", Remote: "http", QPS: func() *float32 { f := float32(50); return &f }(), Timeout: func() *time.Duration { d := time.Second; return &d }()}, false},
+		{"invalid qps", fields{Pod: "pod", Remote: "http", QPS: func() *float32 { f := float32(0); return &f }()}, true},
+		{"invalid burst", fields{Pod: "pod", Remote: "http", Burst: func() *int { i := -1; return &i }()}, true},
+		{"negative timeout", fields{Pod: "pod", Remote: "http", Timeout: func() *time.Duration { d := -time.Second; return &d }()}, true},
+		{"transport", fields{Pod: "pod", Remote: "http", Transport: func() *string { s := TransportWebSocket; return &s }()}, false},
+		{"invalid transport", fields{Pod: "pod", Remote: "http", Transport: func() *string { s := "http3"; return &s }()}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -97,6 +127,16 @@ func TestForward_Validate(t *testing.T) {
 				Service:    tt.fields.Service,
 				Remote:     tt.fields.Remote,
 				Local:      tt.fields.Local,
+				Container:  tt.fields.Container,
+				Undeclared: tt.fields.Undeclared,
+				Wait:       tt.fields.Wait,
+				Balance:    tt.fields.Balance,
+				As:         tt.fields.As,
+				AsGroups:   tt.fields.AsGroups,
+				QPS:        tt.fields.QPS,
+				Burst:      tt.fields.Burst,
+				Timeout:    tt.fields.Timeout,
+				Transport:  tt.fields.Transport,
 			}
 			if err := f.Validate(); (err != nil) != tt.wantErr {
 				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
@@ -104,3 +144,34 @@ func TestForward_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestForward_BindAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    string
+		wantAddr string
+		wantPort int32
+		wantErr  bool
+	}{
+		{"empty", "", DefaultBindAddr, 0, false},
+		{"port", "1234", DefaultBindAddr, 1234, false},
+		{"addr:port", "0.0.0.0:1234", "0.0.0.0", 1234, false},
+		{"invalid", "NaN", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Forward{Local: tt.local}
+			addr, port, err := f.BindAddr()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BindAddr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if addr != tt.wantAddr {
+				t.Errorf("BindAddr() got = %v, want %v", addr, tt.wantAddr)
+			}
+			if port != tt.wantPort {
+				t.Errorf("BindAddr() got1 = %v, want %v", port, tt.wantPort)
+			}
+		})
+	}
+}
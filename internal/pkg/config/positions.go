@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// position is the line and column (both 1-based) a key is defined at.
+type position struct {
+	line   int
+	column int
+}
+
+// positions maps dotted keys to the location they are defined at.
+// The TOML decoder does not expose key locations, so they are recovered by scanning
+// table headers and key/value pairs line by line. Keys within inline tables are not indexed.
+type positions map[string]position
+
+func scanPositions(data []byte) positions {
+	pos := make(positions)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	table := ""
+	multiline := ""
+	for ln := 1; scanner.Scan(); ln++ {
+		line := scanner.Text()
+		if multiline != "" {
+			if strings.Count(line, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " \t")
+		col := len(line) - len(trimmed) + 1
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			header := strings.TrimLeft(trimmed, "[")
+			end := indexUnquoted(header, ']')
+			if end < 0 {
+				continue
+			}
+			table = joinKey(splitKey(header[:end]))
+			pos[table] = position{ln, col}
+			continue
+		}
+		eq := indexUnquoted(trimmed, '=')
+		if eq < 0 {
+			continue
+		}
+		key := joinKey(splitKey(trimmed[:eq]))
+		if table != "" {
+			key = table + "." + key
+		}
+		pos[key] = position{ln, col}
+		for _, delim := range []string{`"""`, `'''`} {
+			if strings.Count(trimmed[eq:], delim)%2 == 1 {
+				multiline = delim
+			}
+		}
+	}
+	return pos
+}
+
+// lookup returns the position of key, or of its closest defined parent if key itself is not defined.
+func (p positions) lookup(key string) (position, bool) {
+	for key != "" {
+		if pos, ok := p[key]; ok {
+			return pos, true
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return position{}, false
+}
+
+// locate sets File, Line and Column of each ValidationError based on its key.
+func (p positions) locate(file string, ves []*ValidationError) {
+	for _, ve := range ves {
+		ve.File = file
+		if pos, ok := p.lookup(ve.Key); ok {
+			ve.Line = pos.line
+			ve.Column = pos.column
+		}
+	}
+}
+
+func indexUnquoted(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+func splitKey(s string) []string {
+	var parts []string
+	for {
+		i := indexUnquoted(s, '.')
+		if i < 0 {
+			break
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+	parts = append(parts, s)
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && (part[0] == '"' || part[0] == '\'') && part[len(part)-1] == part[0] {
+			part = part[1 : len(part)-1]
+		}
+		parts[i] = part
+	}
+	return parts
+}
+
+func joinKey(parts []string) string {
+	return strings.Join(parts, ".")
+}
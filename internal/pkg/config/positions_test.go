@@ -0,0 +1,59 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_scanPositions(t *testing.T) {
+	data := []byte(`relaxed = true
+
+# comment = ignored
+[forwards.nginx]
+  pod = "nginx"
+remote = "a=b"
+description = """
+not = a key
+"""
+
+[forwards."dotted.name"]
+"local" = "8080"
+`)
+	want := positions{
+		"relaxed":                    {1, 1},
+		"forwards.nginx":             {4, 1},
+		"forwards.nginx.pod":         {5, 3},
+		"forwards.nginx.remote":      {6, 1},
+		"forwards.nginx.description": {7, 1},
+		"forwards.dotted.name":       {11, 1},
+		"forwards.dotted.name.local": {12, 1},
+	}
+	if got := scanPositions(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanPositions() got = %v, want %v", got, want)
+	}
+}
+
+func Test_positions_lookup(t *testing.T) {
+	p := positions{"forwards.nginx": {4, 1}, "forwards.nginx.pod": {5, 3}}
+	tests := []struct {
+		name   string
+		key    string
+		want   position
+		wantOk bool
+	}{
+		{"exact", "forwards.nginx.pod", position{5, 3}, true},
+		{"parent", "forwards.nginx.remote", position{4, 1}, true},
+		{"unknown", "relaxed", position{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.lookup(tt.key)
+			if ok != tt.wantOk {
+				t.Errorf("lookup() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got != tt.want {
+				t.Errorf("lookup() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+The JSON Schema is generated from the Forwardfile and Forward types. Keys are derived from the toml tag or the
+lowercase field name, descriptions are taken from the doc tag. The schema tag holds ;-separated options:
+- required: the key must be present
+- oneof=<group>: exactly one key of the group must be present
+- enum=<a>|<b>: allowed values
+- pattern=<regexp>: pattern string values must match
+- inheritable: only the optional settings of the struct are allowed (used for the defaults section)
+- perforward: the optional setting can not be inherited from the defaults section
+*/
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+type schemaOptions struct {
+	required    bool
+	inheritable bool
+	perForward  bool
+	oneOf       string
+	enum        []string
+	pattern     string
+}
+
+func parseSchemaTag(tag string) schemaOptions {
+	var so schemaOptions
+	for _, opt := range strings.Split(tag, ";") {
+		key, val, _ := strings.Cut(opt, "=")
+		switch key {
+		case "required":
+			so.required = true
+		case "inheritable":
+			so.inheritable = true
+		case "perforward":
+			so.perForward = true
+		case "oneof":
+			so.oneOf = val
+		case "enum":
+			so.enum = strings.Split(val, "|")
+		case "pattern":
+			so.pattern = val
+		}
+	}
+	return so
+}
+
+// tomlKey returns the key a struct field is decoded from, or an empty string if the field is not decoded.
+func tomlKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+type schemaGenerator struct {
+	definitions map[string]any
+}
+
+func (sg *schemaGenerator) typeSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]any{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return sg.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": sg.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": sg.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := sg.definitions[t.Name()]; !ok {
+			sg.definitions[t.Name()] = nil
+			sg.definitions[t.Name()] = sg.structSchema(t, false)
+		}
+		return map[string]any{"$ref": "#/definitions/" + t.Name()}
+	default:
+		panic(fmt.Sprintf("unsupported type in schema: %s", t))
+	}
+}
+
+// inheritableSchema references a definition of t that only contains its optional settings.
+func (sg *schemaGenerator) inheritableSchema(t reflect.Type) map[string]any {
+	name := t.Name() + "Defaults"
+	if _, ok := sg.definitions[name]; !ok {
+		sg.definitions[name] = nil
+		sg.definitions[name] = sg.structSchema(t, true)
+	}
+	return map[string]any{"$ref": "#/definitions/" + name}
+}
+
+// structSchema describes the fields of t, or only its optional settings if inheritableOnly is set.
+func (sg *schemaGenerator) structSchema(t reflect.Type, inheritableOnly bool) map[string]any {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+	groups := make(map[string][]string)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := tomlKey(field)
+		if key == "" || (inheritableOnly && !inheritable(field)) {
+			continue
+		}
+		so := parseSchemaTag(field.Tag.Get("schema"))
+		var prop map[string]any
+		if so.inheritable {
+			prop = sg.inheritableSchema(field.Type)
+		} else {
+			prop = sg.typeSchema(field.Type)
+		}
+		if doc := field.Tag.Get("doc"); doc != "" {
+			prop["description"] = doc
+		}
+		if len(so.enum) > 0 {
+			prop["enum"] = so.enum
+		}
+		if so.pattern != "" {
+			prop["pattern"] = so.pattern
+		}
+		if so.required {
+			required = append(required, key)
+		}
+		if so.oneOf != "" {
+			groups[so.oneOf] = append(groups[so.oneOf], key)
+		}
+		properties[key] = prop
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var allOf []any
+	for _, name := range names {
+		var oneOf []any
+		for _, key := range groups[name] {
+			oneOf = append(oneOf, map[string]any{"required": []string{key}})
+		}
+		allOf = append(allOf, map[string]any{"oneOf": oneOf})
+	}
+	if len(allOf) == 1 {
+		schema["oneOf"] = allOf[0].(map[string]any)["oneOf"]
+	} else if len(allOf) > 1 {
+		schema["allOf"] = allOf
+	}
+	return schema
+}
+
+// Schema returns a JSON Schema describing the Forwardfile format, e.g. for use with editor integrations.
+func Schema() ([]byte, error) {
+	sg := &schemaGenerator{definitions: make(map[string]any)}
+	root := sg.structSchema(reflect.TypeOf(Forwardfile{}), false)
+	root["$schema"] = schemaDraft
+	root["title"] = "Forwardfile"
+	root["definitions"] = sg.definitions
+	return json.MarshalIndent(root, "", "  ")
+}
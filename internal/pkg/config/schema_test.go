@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	data, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+	var schema struct {
+		Schema      string   `json:"$schema"`
+		Required    []string `json:"required"`
+		Definitions map[string]struct {
+			Properties map[string]map[string]any `json:"properties"`
+			Required   []string                  `json:"required"`
+			OneOf      []struct {
+				Required []string `json:"required"`
+			} `json:"oneOf"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema() returned invalid JSON: %v", err)
+	}
+	if schema.Schema != schemaDraft {
+		t.Errorf("Schema() $schema = %v, want %v", schema.Schema, schemaDraft)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"forwards"}) {
+		t.Errorf("Schema() required = %v, want [forwards]", schema.Required)
+	}
+	forward, ok := schema.Definitions["Forward"]
+	if !ok {
+		t.Fatalf("Schema() is missing the Forward definition")
+	}
+	typ := reflect.TypeOf(Forward{})
+	for i := 0; i < typ.NumField(); i++ {
+		if _, ok := forward.Properties[tomlKey(typ.Field(i))]; !ok {
+			t.Errorf("Schema() is missing property %s", tomlKey(typ.Field(i)))
+		}
+	}
+	defaults, ok := schema.Definitions["ForwardDefaults"]
+	if !ok {
+		t.Fatalf("Schema() is missing the ForwardDefaults definition")
+	}
+	for _, key := range []string{"pod", "deployment", "service", "remote", "local"} {
+		if _, ok := defaults.Properties[key]; ok {
+			t.Errorf("Schema() ForwardDefaults allows per-forward key %s", key)
+		}
+	}
+	if _, ok := defaults.Properties["proxy_url"]; !ok {
+		t.Errorf("Schema() ForwardDefaults is missing proxy_url")
+	}
+	var targets []string
+	for _, o := range forward.OneOf {
+		targets = append(targets, o.Required...)
+	}
+	if !reflect.DeepEqual(targets, []string{"pod", "deployment", "service"}) {
+		t.Errorf("Schema() oneOf = %v, want [pod deployment service]", targets)
+	}
+}
+
+func Test_parseSchemaTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		want schemaOptions
+	}{
+		{"empty", "", schemaOptions{}},
+		{"required", "required", schemaOptions{required: true}},
+		{"combined", "oneof=target;enum=a|b;pattern=^[a-z]+$", schemaOptions{oneOf: "target", enum: []string{"a", "b"}, pattern: "^[a-z]+$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSchemaTag(tt.tag); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSchemaTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
 	"github.com/tmsmr/k4wd/internal/pkg/forwarder"
+	"net"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
+	"sort"
+	"strconv"
 	"sync"
 )
 
@@ -22,12 +24,41 @@ const (
 	FormatNoExport
 	FormatPS
 	FormatCmd
+	FormatDotenv
+	FormatDirenv
+	FormatFish
+	FormatNushell
+	FormatYAML
+	FormatDockerEnv
 )
 
+var formats = map[string]EnvFormat{
+	"env":       FormatDefault,
+	"no-export": FormatNoExport,
+	"json":      FormatJSON,
+	"ps":        FormatPS,
+	"cmd":       FormatCmd,
+	"dotenv":    FormatDotenv,
+	"direnv":    FormatDirenv,
+	"fish":      FormatFish,
+	"nu":        FormatNushell,
+	"yaml":      FormatYAML,
+	"docker":    FormatDockerEnv,
+}
+
+// ParseFormat returns the format with the given name, see config.EnvFormats.
+func ParseFormat(name string) (EnvFormat, error) {
+	f, ok := formats[name]
+	if !ok {
+		return FormatDefault, fmt.Errorf("unknown format: %s", name)
+	}
+	return f, nil
+}
+
 const (
 	filePrefix = "k4wd_env_"
-	filePerm   = 0644
-	envSuffix  = "ADDR"
+	filePerm   = 0600
+	exportPerm = 0644
 )
 
 type envEntry struct {
@@ -35,9 +66,42 @@ type envEntry struct {
 	Value string `json:"value"`
 }
 
+// Forward states recorded in the envfile.
+const (
+	stateStarting = "starting"
+	stateReady    = "ready"
+	stateFailed   = "failed"
+)
+
+// forwardState is the state of a forward and the error it failed with, if any.
+type forwardState struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// record is the content of an envfile: the entries, the state of each forward and the PID of the k4wd instance that owns them.
+type record struct {
+	PID      int                     `json:"pid"`
+	Forwards map[string]forwardState `json:"forwards"`
+	Entries  []envEntry              `json:"entries"`
+}
+
+// StaleError is returned by Load if the k4wd instance that wrote the envfile is no longer running, e.g. after it was killed.
+type StaleError struct {
+	Path string
+	PID  int
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("stale envfile %s: k4wd (pid %d) is no longer running", e.Path, e.PID)
+}
+
 type Envfile struct {
 	mu   sync.Mutex
 	path string
+	lock *os.File
+	// failed holds the errors of the forwards that failed, by name
+	failed map[string]string
 }
 
 func New(ref string) (*Envfile, error) {
@@ -45,10 +109,14 @@ func New(ref string) (*Envfile, error) {
 	if err != nil {
 		return nil, err
 	}
+	dir, err := runtimeDir()
+	if err != nil {
+		return nil, err
+	}
 	hash := sha256.New()
 	hash.Write([]byte(abs))
 	return &Envfile{
-		path: filepath.Join(os.TempDir(), fmt.Sprintf("%s%x", filePrefix, hash.Sum(nil))),
+		path: filepath.Join(dir, fmt.Sprintf("%s%x", filePrefix, hash.Sum(nil))),
 	}, nil
 }
 
@@ -66,22 +134,105 @@ func (ef *Envfile) Path() string {
 	return ef.path
 }
 
+// envData returns the data the env templates of the forward are rendered with.
+func envData(fwd *forwarder.Forwarder) config.EnvData {
+	addr, port := fwd.Bind()
+	pod, _ := fwd.Target()
+	data := config.EnvData{
+		Name:      fwd.Name,
+		Host:      addr,
+		Port:      port,
+		Addr:      net.JoinHostPort(addr, strconv.Itoa(int(port))),
+		Pod:       pod,
+		Namespace: fwd.Namespace,
+	}
+	if fwd.Context != nil {
+		data.Context = *fwd.Context
+	}
+	return data
+}
+
+// entries returns the variables exported for the forward: <NAME>_ADDR, <NAME>_HOST, <NAME>_PORT and the rendered env templates.
+func entries(fwd *forwarder.Forwarder) ([]envEntry, error) {
+	data := envData(fwd)
+	prefix := config.EnvPrefix(fwd.Name)
+	res := []envEntry{
+		{Addr: fmt.Sprintf("%s_%s", prefix, config.EnvSuffixAddr), Value: data.Addr},
+		{Addr: fmt.Sprintf("%s_%s", prefix, config.EnvSuffixHost), Value: data.Host},
+		{Addr: fmt.Sprintf("%s_%s", prefix, config.EnvSuffixPort), Value: strconv.Itoa(int(data.Port))},
+	}
+	env, err := fwd.RenderEnv(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fwd.Name, err)
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res = append(res, envEntry{Addr: name, Value: env[name]})
+	}
+	return res, nil
+}
+
+// ready reports whether the forward has started, without blocking.
+func ready(fwd *forwarder.Forwarder) bool {
+	select {
+	case <-fwd.Ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// state returns the state of the forward, failed if Fail was called for it.
+func (ef *Envfile) state(fwd *forwarder.Forwarder) forwardState {
+	if err, ok := ef.failed[fwd.Name]; ok {
+		return forwardState{State: stateFailed, Error: err}
+	}
+	if ready(fwd) {
+		return forwardState{State: stateReady}
+	}
+	return forwardState{State: stateStarting}
+}
+
+// Fail marks the forward as failed with err, so waiting for it stops. It is written with the next Update.
+func (ef *Envfile) Fail(name string, err error) {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	if ef.failed == nil {
+		ef.failed = make(map[string]string)
+	}
+	ef.failed[name] = err.Error()
+}
+
+// Update writes the entries of all forwards atomically, along with their state and the PID of this process.
 func (ef *Envfile) Update(forwards map[string]*forwarder.Forwarder) error {
 	ef.mu.Lock()
 	defer ef.mu.Unlock()
-	re := regexp.MustCompile(`\W`)
-	addrs := make([]envEntry, 0)
-	for _, fwd := range forwards {
-		addrs = append(addrs, envEntry{
-			Addr:  fmt.Sprintf("%s_%s", strings.ToUpper(re.ReplaceAllString(fwd.Name, "_")), envSuffix),
-			Value: fmt.Sprintf("%s:%d", fwd.BindAddr, fwd.BindPort),
-		})
-	}
-	data, err := json.MarshalIndent(addrs, "", "    ")
+	names := make([]string, 0, len(forwards))
+	for name := range forwards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rec := record{PID: os.Getpid(), Forwards: make(map[string]forwardState), Entries: make([]envEntry, 0)}
+	for _, name := range names {
+		res, err := entries(forwards[name])
+		if err != nil {
+			return err
+		}
+		rec.Entries = append(rec.Entries, res...)
+		rec.Forwards[name] = ef.state(forwards[name])
+	}
+	data, err := json.MarshalIndent(rec, "", "    ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(ef.path, data, filePerm)
+	if err := ensureDir(filepath.Dir(ef.path)); err != nil {
+		return err
+	}
+	return writeAtomic(ef.path, data, filePerm)
 }
 
 func (ef *Envfile) Remove() error {
@@ -91,39 +242,78 @@ func (ef *Envfile) Remove() error {
 	return os.Remove(ef.path)
 }
 
-func (ef *Envfile) Load(f EnvFormat) ([]byte, error) {
+// read returns the record of the envfile, or a *StaleError if the process that wrote it is gone.
+func (ef *Envfile) read() (*record, error) {
 	exists, err := ef.exists()
 	if err != nil {
-		return []byte{}, err
+		return nil, err
 	}
 	if !exists {
-		return []byte{}, fmt.Errorf("no env available")
+		return nil, fmt.Errorf("no env available")
 	}
 	data, err := os.ReadFile(ef.path)
-	if f == FormatJSON {
-		return data, err
+	if err != nil {
+		return nil, err
 	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	if !processAlive(rec.PID) {
+		return nil, &StaleError{Path: ef.path, PID: rec.PID}
+	}
+	return &rec, nil
+}
+
+func (ef *Envfile) Load(f EnvFormat) ([]byte, error) {
+	rec, err := ef.read()
 	if err != nil {
 		return []byte{}, err
 	}
-	var addrs []envEntry
-	if err := json.Unmarshal(data, &addrs); err != nil {
-		return []byte{}, err
+	addrs := rec.Entries
+	if addrs == nil {
+		addrs = []envEntry{}
+	}
+	if f == FormatJSON {
+		return json.MarshalIndent(addrs, "", "    ")
 	}
 	var content bytes.Buffer
+	if f == FormatDirenv {
+		// reload the environment whenever the forwards change
+		content.WriteString(fmt.Sprintf("watch_file %s\n", shellQuote(ef.path)))
+	}
 	for _, addr := range addrs {
 		switch f {
-		case FormatDefault:
-			content.WriteString(fmt.Sprintf("export %s=%s\n", addr.Addr, addr.Value))
+		case FormatDefault, FormatDirenv:
+			content.WriteString(fmt.Sprintf("export %s=%s\n", addr.Addr, shellQuote(addr.Value)))
 			break
 		case FormatNoExport:
-			content.WriteString(fmt.Sprintf("%s=%s\n", addr.Addr, addr.Value))
+			content.WriteString(fmt.Sprintf("%s=%s\n", addr.Addr, shellQuote(addr.Value)))
 			break
 		case FormatPS:
-			content.WriteString(fmt.Sprintf("$Env:%s=\"%s\"\n", addr.Addr, addr.Value))
+			content.WriteString(fmt.Sprintf("$Env:%s=%s\n", addr.Addr, psQuote(addr.Value)))
 			break
 		case FormatCmd:
-			content.WriteString(fmt.Sprintf("set %s=%s\n", addr.Addr, addr.Value))
+			content.WriteString(fmt.Sprintf("set %s=%s\n", addr.Addr, cmdEscape(addr.Value)))
+			break
+		case FormatDotenv:
+			content.WriteString(fmt.Sprintf("%s=%s\n", addr.Addr, dotenvQuote(addr.Value)))
+			break
+		case FormatFish:
+			content.WriteString(fmt.Sprintf("set -gx %s %s;\n", addr.Addr, fishQuote(addr.Value)))
+			break
+		case FormatNushell:
+			content.WriteString(fmt.Sprintf("$env.%s = %s\n", addr.Addr, nuQuote(addr.Value)))
+			break
+		case FormatYAML:
+			content.WriteString(fmt.Sprintf("%s: %s\n", addr.Addr, yamlQuote(addr.Value)))
+			break
+		case FormatDockerEnv:
+			value, err := dockerValue(addr.Value)
+			if err != nil {
+				return []byte{}, err
+			}
+			content.WriteString(fmt.Sprintf("%s=%s\n", addr.Addr, value))
 			break
 		default:
 			return []byte{}, fmt.Errorf("unsupported format")
@@ -131,3 +321,17 @@ func (ef *Envfile) Load(f EnvFormat) ([]byte, error) {
 	}
 	return content.Bytes(), nil
 }
+
+// Export writes the environment in the given format to path, e.g. a project-local .env file.
+func (ef *Envfile) Export(path string, f EnvFormat) error {
+	content, err := ef.Load(f)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, content, exportPerm)
+}
+
+// Blank leaves an empty file at path, for consumers that expect the file to exist.
+func Blank(path string) error {
+	return writeAtomic(path, []byte{}, exportPerm)
+}
@@ -1,9 +1,12 @@
 package envfile
 
 import (
+	"errors"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
 	"github.com/tmsmr/k4wd/internal/pkg/forwarder"
 	"os"
 	"path"
+	"reflect"
 	"regexp"
 	"testing"
 )
@@ -101,11 +104,17 @@ func TestEnvfile_Formats(t *testing.T) {
 		want    []byte
 		wantErr bool
 	}{
-		{"FormatJSON", args{FormatJSON}, []byte(`[{"addr": "TEST_ADDR", "value": "test:8080"}]`), false},
-		{"FormatDefault", args{FormatDefault}, []byte(`export TEST_ADDR=test:8080`), false},
-		{"FormatNoExport", args{FormatNoExport}, []byte(`TEST_ADDR=test:8080`), false},
-		{"FormatPS", args{FormatPS}, []byte(`$Env:TEST_ADDR="test:8080"`), false},
-		{"FormatCmd", args{FormatCmd}, []byte(`set TEST_ADDR=test:8080`), false},
+		{"FormatJSON", args{FormatJSON}, []byte(`[{"addr": "TEST_ADDR", "value": "test:8080"}, {"addr": "TEST_HOST", "value": "test"}, {"addr": "TEST_PORT", "value": "8080"}]`), false},
+		{"FormatDefault", args{FormatDefault}, []byte(`export TEST_ADDR=test:8080 export TEST_HOST=test export TEST_PORT=8080`), false},
+		{"FormatNoExport", args{FormatNoExport}, []byte(`TEST_ADDR=test:8080 TEST_HOST=test TEST_PORT=8080`), false},
+		{"FormatPS", args{FormatPS}, []byte(`$Env:TEST_ADDR="test:8080" $Env:TEST_HOST="test" $Env:TEST_PORT="8080"`), false},
+		{"FormatCmd", args{FormatCmd}, []byte(`set TEST_ADDR=test:8080 set TEST_HOST=test set TEST_PORT=8080`), false},
+		{"FormatDotenv", args{FormatDotenv}, []byte(`TEST_ADDR=test:8080 TEST_HOST=test TEST_PORT=8080`), false},
+		{"FormatDirenv", args{FormatDirenv}, []byte(`watch_file ` + shellQuote(ef.Path()) + ` export TEST_ADDR=test:8080 export TEST_HOST=test export TEST_PORT=8080`), false},
+		{"FormatFish", args{FormatFish}, []byte(`set -gx TEST_ADDR test:8080; set -gx TEST_HOST test; set -gx TEST_PORT 8080;`), false},
+		{"FormatNushell", args{FormatNushell}, []byte(`$env.TEST_ADDR = "test:8080" $env.TEST_HOST = "test" $env.TEST_PORT = "8080"`), false},
+		{"FormatYAML", args{FormatYAML}, []byte(`TEST_ADDR: "test:8080" TEST_HOST: "test" TEST_PORT: "8080"`), false},
+		{"FormatDockerEnv", args{FormatDockerEnv}, []byte(`TEST_ADDR=test:8080 TEST_HOST=test TEST_PORT=8080`), false},
 		{"invalid format", args{-1}, []byte{}, true},
 	}
 	for _, tt := range tests {
@@ -121,3 +130,91 @@ func TestEnvfile_Formats(t *testing.T) {
 		})
 	}
 }
+
+func Test_entries(t *testing.T) {
+	kubecontext := "kind"
+	fwd := &forwarder.Forwarder{
+		Name: "db-primary",
+		Forward: config.Forward{
+			Context: &kubecontext,
+			Env: map[string]string{
+				"DATABASE_URL": "postgres://app@{{.Host}}:{{.Port}}/app",
+				"DB_TARGET":    "{{.Context}}/{{.Namespace}}/{{.Pod}}",
+			},
+		},
+		BindAddr:  "127.0.0.1",
+		BindPort:  5432,
+		Namespace: "k4wd",
+		TargetPod: "postgres-0",
+	}
+	got, err := entries(fwd)
+	if err != nil {
+		t.Fatalf("entries() error = %v", err)
+	}
+	want := []envEntry{
+		{"DB_PRIMARY_ADDR", "127.0.0.1:5432"},
+		{"DB_PRIMARY_HOST", "127.0.0.1"},
+		{"DB_PRIMARY_PORT", "5432"},
+		{"DATABASE_URL", "postgres://app@127.0.0.1:5432/app"},
+		{"DB_TARGET", "kind/k4wd/postgres-0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries() = %v, want %v", got, want)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if len(formats) != len(config.EnvFormats) {
+		t.Errorf("formats has %d entries, config.EnvFormats %d", len(formats), len(config.EnvFormats))
+	}
+	for _, name := range config.EnvFormats {
+		if _, err := ParseFormat(name); err != nil {
+			t.Errorf("ParseFormat(%s) error = %v", name, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("ParseFormat(xml) error = nil, want error")
+	}
+}
+
+func TestEnvfile_Export(t *testing.T) {
+	ef, err := New("Forwardfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdsmock := map[string]*forwarder.Forwarder{"test": {Name: "test", BindAddr: "test", BindPort: 8080}}
+	if err := ef.Update(fwdsmock); err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Remove()
+	export := path.Join(t.TempDir(), ".env.k4wd")
+	if err := ef.Export(export, FormatDotenv); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	data, err := os.ReadFile(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "TEST_ADDR=test:8080\nTEST_HOST=test\nTEST_PORT=8080\n"; string(data) != want {
+		t.Errorf("Export() wrote %q, want %q", data, want)
+	}
+	if err := Blank(export); err != nil {
+		t.Fatalf("Blank() error = %v", err)
+	}
+	if data, _ := os.ReadFile(export); len(data) != 0 {
+		t.Errorf("Blank() left %q", data)
+	}
+}
+
+func TestEnvfile_Load_stale(t *testing.T) {
+	stale := path.Join(t.TempDir(), "stale")
+	if err := os.WriteFile(stale, []byte(`{"pid": 1073741824, "entries": []}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ef := &Envfile{path: stale}
+	_, err := ef.Load(FormatDefault)
+	var se *StaleError
+	if !errors.As(err, &se) || se.PID != 1<<30 {
+		t.Errorf("Load() error = %v, want *StaleError for pid %d", err, 1<<30)
+	}
+}
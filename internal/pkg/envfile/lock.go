@@ -0,0 +1,97 @@
+package envfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const lockSuffix = ".lock"
+
+var errLocked = errors.New("locked")
+
+// LockedError is returned by Lock if another k4wd instance runs the same Forwardfile.
+type LockedError struct {
+	Path string
+	PID  int
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("k4wd is already running for this Forwardfile (pid %d, lock %s)", e.PID, e.Path)
+}
+
+func (ef *Envfile) lockPath() string {
+	return ef.path + lockSuffix
+}
+
+// readPID returns the PID recorded in the lock file, 0 if it can not be read.
+func readPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// Lock acquires the advisory lock of the envfile, so only one instance runs a Forwardfile at a time.
+// The lock is held until Unlock is called or the process exits, even if it is killed. The lock file is not removed,
+// since another instance may already have opened it.
+func (ef *Envfile) Lock() error {
+	if err := ensureDir(filepath.Dir(ef.path)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ef.lockPath(), os.O_RDWR|os.O_CREATE, filePerm)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return &LockedError{Path: ef.lockPath(), PID: readPID(ef.lockPath())}
+		}
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		f.Close()
+		return err
+	}
+	ef.lock = f
+	return nil
+}
+
+// Unlock releases the lock acquired by Lock.
+func (ef *Envfile) Unlock() error {
+	if ef.lock == nil {
+		return nil
+	}
+	err := ef.lock.Close()
+	ef.lock = nil
+	return err
+}
+
+// Owner reports whether a k4wd instance holds the lock of the envfile and its PID, which is 0 if it has not been recorded yet.
+func (ef *Envfile) Owner() (pid int, running bool, err error) {
+	f, err := os.Open(ef.lockPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		if errors.Is(err, errLocked) {
+			return readPID(ef.lockPath()), true, nil
+		}
+		return 0, false, err
+	}
+	return 0, false, nil
+}
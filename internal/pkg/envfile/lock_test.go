@@ -0,0 +1,38 @@
+package envfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvfile_Lock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "envfile")
+	first, second := &Envfile{path: path}, &Envfile{path: path}
+	if _, running, err := first.Owner(); err != nil || running {
+		t.Fatalf("Owner() = %v, %v before Lock(), want not running", running, err)
+	}
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	err := second.Lock()
+	var le *LockedError
+	if !errors.As(err, &le) || le.PID != os.Getpid() {
+		t.Errorf("Lock() error = %v, want *LockedError for pid %d", err, os.Getpid())
+	}
+	pid, running, err := second.Owner()
+	if err != nil || !running || pid != os.Getpid() {
+		t.Errorf("Owner() = %d, %v, %v, want %d, true", pid, running, err, os.Getpid())
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if _, running, err := second.Owner(); err != nil || running {
+		t.Errorf("Owner() = %v, %v after Unlock(), want not running", running, err)
+	}
+	if err := second.Lock(); err != nil {
+		t.Errorf("Lock() error = %v after Unlock()", err)
+	}
+	_ = second.Unlock()
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package envfile
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile locks a byte far beyond the content of f without blocking, so the PID stays readable for other processes.
+// The lock is released when f is closed.
+func lockFile(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: 0x7fffffff}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package envfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+Values are only quoted if they contain characters that need it, so common values like host:port stay readable.
+Each format uses the quoting of its consumer:
+- POSIX shells and direnv: single quotes, ' is written as '\''
+- dotenv: single quotes, or double quotes with \\, \", \n and \$ escapes if the value contains ' or a newline
+- fish: single quotes with \\ and \' escapes
+- nushell: double quotes with \\, \", \n, \r and \t escapes
+- YAML: double quotes with JSON escapes, without escaping HTML characters
+- PowerShell: double quotes with ` escapes for `, " and $
+- cmd: ^ escapes for special characters
+- Docker env-file: values are taken literally and can not contain newlines
+*/
+
+var safeValue = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]*$`)
+
+func shellQuote(s string) string {
+	if safeValue.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func dotenvQuote(s string) string {
+	if safeValue.MatchString(s) {
+		return s
+	}
+	if !strings.ContainsAny(s, "'\n") {
+		return "'" + s + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, `$`, `\$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func fishQuote(s string) string {
+	if safeValue.MatchString(s) {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(s) + "'"
+}
+
+func nuQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// yamlQuote returns s as a JSON string, which is a valid double-quoted YAML scalar.
+func yamlQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func psQuote(s string) string {
+	r := strings.NewReplacer("`", "``", `"`, "`\"", `$`, "`$")
+	return `"` + r.Replace(s) + `"`
+}
+
+func cmdEscape(s string) string {
+	r := strings.NewReplacer(`^`, `^^`, `&`, `^&`, `|`, `^|`, `<`, `^<`, `>`, `^>`)
+	return r.Replace(s)
+}
+
+func dockerValue(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", fmt.Errorf("value of env-file entries can not contain newlines")
+	}
+	return s, nil
+}
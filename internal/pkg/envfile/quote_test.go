@@ -0,0 +1,43 @@
+package envfile
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		quote func(string) string
+		value string
+		want  string
+	}{
+		{"shell safe", shellQuote, "127.0.0.1:8080", "127.0.0.1:8080"},
+		{"shell url", shellQuote, "postgres://app@127.0.0.1:5432/app?sslmode=disable", "'postgres://app@127.0.0.1:5432/app?sslmode=disable'"},
+		{"shell single quote", shellQuote, "it's", `'it'\''s'`},
+		{"dotenv safe", dotenvQuote, "127.0.0.1:8080", "127.0.0.1:8080"},
+		{"dotenv spaces", dotenvQuote, "a $b", "'a $b'"},
+		{"dotenv single quote", dotenvQuote, `it's "$x"`, `"it's \"\$x\""`},
+		{"dotenv newline", dotenvQuote, "a\nb", `"a\nb"`},
+		{"fish", fishQuote, `it's a \ test`, `'it\'s a \\ test'`},
+		{"nushell", nuQuote, "say \"hi\"\n", `"say \"hi\"\n"`},
+		{"nushell ampersand", nuQuote, `a&b<c> \d`, `"a&b<c> \\d"`},
+		{"yaml", yamlQuote, "say \"hi\"\n", `"say \"hi\"\n"`},
+		{"yaml ampersand", yamlQuote, "postgres://app@127.0.0.1:5432/app?sslmode=disable&connect_timeout=5", `"postgres://app@127.0.0.1:5432/app?sslmode=disable&connect_timeout=5"`},
+		{"powershell", psQuote, "$HOME `x` \"y\"", "\"`$HOME ``x`` `\"y`\"\""},
+		{"cmd", cmdEscape, "a&b|c<d>e^f", "a^&b^|c^<d^>e^^f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quote(tt.value); got != tt.want {
+				t.Errorf("quote(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dockerValue(t *testing.T) {
+	if got, err := dockerValue(`a "b" 'c'`); err != nil || got != `a "b" 'c'` {
+		t.Errorf("dockerValue() = %s, %v, want value unchanged", got, err)
+	}
+	if _, err := dockerValue("a\nb"); err == nil {
+		t.Errorf("dockerValue() error = nil, want error for newline")
+	}
+}
@@ -0,0 +1,64 @@
+package envfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const runtimeDirPerm = 0700
+
+// runtimeDir returns the per-user directory envfiles are kept in: k4wd in $XDG_RUNTIME_DIR,
+// or a directory named after the user in the temp dir on systems without one.
+func runtimeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "k4wd"), nil
+	}
+	uid, err := userID()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("k4wd-%s", uid)), nil
+}
+
+// ensureDir creates dir if necessary and makes sure only the current user can access it.
+// Chmod fails if the directory belongs to another user, e.g. because it was created in the shared temp dir beforehand.
+func ensureDir(dir string) error {
+	if err := os.MkdirAll(dir, runtimeDirPerm); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return os.Chmod(dir, runtimeDirPerm)
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it, so readers never see partial content.
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
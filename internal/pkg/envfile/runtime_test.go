@@ -0,0 +1,97 @@
+package envfile
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_runtimeDir(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", xdg)
+	dir, err := runtimeDir()
+	if err != nil {
+		t.Fatalf("runtimeDir() error = %v", err)
+	}
+	if want := filepath.Join(xdg, "k4wd"); dir != want {
+		t.Errorf("runtimeDir() = %v, want %v", dir, want)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	dir, err = runtimeDir()
+	if err != nil {
+		t.Fatalf("runtimeDir() error = %v", err)
+	}
+	if filepath.Dir(dir) != filepath.Clean(os.TempDir()) {
+		t.Errorf("runtimeDir() = %v, want a directory in %v", dir, os.TempDir())
+	}
+}
+
+func Test_ensureDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "k4wd")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureDir(dir); err != nil {
+		t.Fatalf("ensureDir() error = %v", err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != runtimeDirPerm {
+		t.Errorf("ensureDir() perm = %v, want %v", fi.Mode().Perm(), os.FileMode(runtimeDirPerm))
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureDir(file); err == nil {
+		t.Errorf("ensureDir() error = nil for a file, want error")
+	}
+}
+
+func Test_writeAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "envfile")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAtomic(path, []byte("new"), filePerm); err != nil {
+		t.Fatalf("writeAtomic() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("writeAtomic() content = %q, want %q", data, "new")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != filePerm {
+		t.Errorf("writeAtomic() perm = %v, want %v", fi.Mode().Perm(), os.FileMode(filePerm))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("writeAtomic() left %d files, want 1", len(entries))
+	}
+}
+
+func Test_processAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Errorf("processAlive() = false for this process")
+	}
+	// beyond the maximum PID on Linux
+	if processAlive(1 << 30) {
+		t.Errorf("processAlive() = true for a PID that can not exist")
+	}
+	if processAlive(0) {
+		t.Errorf("processAlive(0) = true")
+	}
+}
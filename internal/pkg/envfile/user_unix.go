@@ -0,0 +1,14 @@
+//go:build !windows
+
+package envfile
+
+import (
+	"os"
+	"strconv"
+)
+
+// userID identifies the current user. The UID is used directly, since looking it up requires a passwd entry
+// or $USER and $HOME without cgo, which containers running as an arbitrary UID often lack.
+func userID() (string, error) {
+	return strconv.Itoa(os.Getuid()), nil
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package envfile
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_runtimeDir_withoutUserEnv(t *testing.T) {
+	// without cgo, looking up the user fails if these are unset and the UID has no passwd entry
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("USER", "")
+	t.Setenv("HOME", "")
+	dir, err := runtimeDir()
+	if err != nil {
+		t.Fatalf("runtimeDir() error = %v", err)
+	}
+	if want := filepath.Join(os.TempDir(), "k4wd-"+strconv.Itoa(os.Getuid())); dir != want {
+		t.Errorf("runtimeDir() = %v, want %v", dir, want)
+	}
+}
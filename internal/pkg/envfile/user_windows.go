@@ -0,0 +1,17 @@
+//go:build windows
+
+package envfile
+
+import (
+	"os/user"
+	"path/filepath"
+)
+
+// userID identifies the current user by the SID, since Windows has no UIDs.
+func userID() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(u.Uid), nil
+}
@@ -0,0 +1,84 @@
+package envfile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const waitInterval = 250 * time.Millisecond
+
+// UnknownForwardError is returned by Wait if a forward that is waited for is not defined.
+type UnknownForwardError struct {
+	Name string
+}
+
+func (e *UnknownForwardError) Error() string {
+	return fmt.Sprintf("unknown forward: %s", e.Name)
+}
+
+// FailedForwardError is returned by Wait if a forward that is waited for failed.
+type FailedForwardError struct {
+	Name string
+	Err  string
+}
+
+func (e *FailedForwardError) Error() string {
+	return fmt.Sprintf("forward %s failed: %s", e.Name, e.Err)
+}
+
+// checkReady returns an error describing why the environment is not complete yet: the envfile is missing or stale,
+// or one of the named forwards, or of all forwards if names is empty, is not ready.
+func (ef *Envfile) checkReady(names []string) error {
+	rec, err := ef.read()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		for name := range rec.Forwards {
+			names = append(names, name)
+		}
+	}
+	var pending []string
+	for _, name := range names {
+		state, ok := rec.Forwards[name]
+		if !ok {
+			return &UnknownForwardError{Name: name}
+		}
+		switch state.State {
+		case stateReady:
+		case stateFailed:
+			return &FailedForwardError{Name: name, Err: state.Error}
+		default:
+			pending = append(pending, name)
+		}
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return fmt.Errorf("forwards not ready: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Wait blocks until the envfile is available and the named forwards, or all forwards if names is empty, are ready.
+// It fails if the timeout passes first, a name is not a forward of the running instance or one of the forwards failed.
+func (ef *Envfile) Wait(names []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := ef.checkReady(names)
+		if err == nil {
+			return nil
+		}
+		var ue *UnknownForwardError
+		var fe *FailedForwardError
+		if errors.As(err, &ue) || errors.As(err, &fe) {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("environment not ready after %s: %v", timeout, err)
+		}
+		time.Sleep(waitInterval)
+	}
+}
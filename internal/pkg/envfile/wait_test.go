@@ -0,0 +1,75 @@
+package envfile
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/tmsmr/k4wd/internal/pkg/forwarder"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRecord(t *testing.T, path string, states map[string]string) {
+	forwards := make(map[string]forwardState)
+	for name, state := range states {
+		forwards[name] = forwardState{State: state}
+	}
+	data, err := json.Marshal(record{PID: os.Getpid(), Forwards: forwards, Entries: []envEntry{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAtomic(path, data, filePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvfile_Wait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "envfile")
+	ef := &Envfile{path: path}
+	if err := ef.Wait(nil, 0); err == nil {
+		t.Errorf("Wait() error = nil without envfile, want error")
+	}
+	writeRecord(t, path, map[string]string{"a": stateReady, "b": stateStarting})
+	if err := ef.Wait([]string{"a"}, 0); err != nil {
+		t.Errorf("Wait(a) error = %v", err)
+	}
+	if err := ef.Wait(nil, 2*waitInterval); err == nil {
+		t.Errorf("Wait() error = nil with b not ready, want error")
+	}
+	var ue *UnknownForwardError
+	if err := ef.Wait([]string{"c"}, time.Minute); !errors.As(err, &ue) {
+		t.Errorf("Wait(c) error = %v, want *UnknownForwardError", err)
+	}
+	go func() {
+		time.Sleep(waitInterval)
+		writeRecord(t, path, map[string]string{"a": stateReady, "b": stateReady})
+	}()
+	if err := ef.Wait(nil, time.Minute); err != nil {
+		t.Errorf("Wait() error = %v after b became ready", err)
+	}
+}
+
+func TestEnvfile_Wait_failed(t *testing.T) {
+	ef := &Envfile{path: filepath.Join(t.TempDir(), "envfile")}
+	fwds := map[string]*forwarder.Forwarder{
+		"a": {Name: "a", BindAddr: "127.0.0.1", Ready: make(chan struct{})},
+		"b": {Name: "b", BindAddr: "127.0.0.1", Ready: make(chan struct{})},
+	}
+	close(fwds["a"].Ready)
+	ef.Fail("b", errors.New("pod not found"))
+	if err := ef.Update(fwds); err != nil {
+		t.Fatal(err)
+	}
+	if err := ef.Wait([]string{"a"}, 0); err != nil {
+		t.Errorf("Wait(a) error = %v", err)
+	}
+	start := time.Now()
+	var fe *FailedForwardError
+	if err := ef.Wait(nil, time.Minute); !errors.As(err, &fe) || fe.Name != "b" || fe.Err != "pod not found" {
+		t.Errorf("Wait() error = %v, want *FailedForwardError for b", err)
+	}
+	if time.Since(start) > waitInterval {
+		t.Errorf("Wait() waited for failed forward")
+	}
+}
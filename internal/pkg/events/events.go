@@ -0,0 +1,59 @@
+package events
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"time"
+)
+
+type Type string
+
+// Types of the events emitted for a forward over its lifetime.
+const (
+	ForwardStarted Type = "forward_started"
+	Ready          Type = "ready"
+	Reconnecting   Type = "reconnecting"
+	Failed         Type = "failed"
+	Stopped        Type = "stopped"
+)
+
+// Event is a single line of the event stream. Fields that do not apply to the type are omitted.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    Type      `json:"event"`
+	Forward string    `json:"forward"`
+	// Addr is the local address of the forward, set for ready
+	Addr string `json:"addr,omitempty"`
+	// Pod is the pod forwarded to, or the deployment or service of balanced forwards, set for ready and reconnecting
+	Pod string `json:"pod,omitempty"`
+	// Error is the cause of failed and reconnecting
+	Error string `json:"error,omitempty"`
+}
+
+// Emitter writes events as newline-delimited JSON. A nil Emitter discards all events.
+type Emitter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func New(w io.Writer) *Emitter {
+	return &Emitter{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Emit writes the event, the time is set to the current time if it is not set already.
+func (e *Emitter) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ev.Time.IsZero() {
+		ev.Time = e.now().UTC()
+	}
+	if err := e.enc.Encode(ev); err != nil {
+		log.Warnf("failed to emit %s event: %v", ev.Type, err)
+	}
+}
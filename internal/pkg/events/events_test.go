@@ -0,0 +1,28 @@
+package events
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEmitter_Emit(t *testing.T) {
+	var buf bytes.Buffer
+	e := New(&buf)
+	e.now = func() time.Time { return time.Date(2024, 5, 1, 9, 2, 47, 0, time.UTC) }
+	e.Emit(Event{Type: ForwardStarted, Forward: "db"})
+	e.Emit(Event{Type: Ready, Forward: "db", Addr: "127.0.0.1:5432", Pod: "postgres-0"})
+	e.Emit(Event{Type: Failed, Forward: "db", Error: "lost connection to pod"})
+	want := `{"time":"2024-05-01T09:02:47Z","event":"forward_started","forward":"db"}
+{"time":"2024-05-01T09:02:47Z","event":"ready","forward":"db","addr":"127.0.0.1:5432","pod":"postgres-0"}
+{"time":"2024-05-01T09:02:47Z","event":"failed","forward":"db","error":"lost connection to pod"}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Emit() wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEmitter_Emit_nil(t *testing.T) {
+	var e *Emitter
+	e.Emit(Event{Type: Stopped, Forward: "db"})
+}
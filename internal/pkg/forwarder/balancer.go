@@ -0,0 +1,494 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"github.com/tmsmr/k4wd/internal/pkg/events"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	syncInterval = 5 * time.Second
+	sessionAddr  = "127.0.0.1"
+)
+
+// errCannotFollow marks deployment forwards that cannot follow rollouts and forward to a single pod directly instead.
+var errCannotFollow = errors.New("cannot follow rollouts")
+
+// backend is a port-forward session to a single pod. Connections are proxied to the internal address of the session.
+type backend struct {
+	pod      string
+	port     int32
+	addr     string
+	stop     chan struct{}
+	once     sync.Once
+	active   int
+	draining bool
+}
+
+func (be *backend) shutdown() {
+	be.once.Do(func() { close(be.stop) })
+}
+
+// balancer distributes connections across backends. Draining backends do not get new connections
+// and are shut down as soon as their active connections are done.
+type balancer struct {
+	mu       sync.Mutex
+	strategy string
+	backends []*backend
+	next     int
+}
+
+func newBalancer(strategy string) *balancer {
+	return &balancer{strategy: strategy}
+}
+
+// add registers a backend, backends are kept sorted by pod name.
+func (b *balancer) add(be *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backends = append(b.backends, be)
+	sort.SliceStable(b.backends, func(i, j int) bool { return b.backends[i].pod < b.backends[j].pod })
+}
+
+// remove drops a backend, e.g. because its session ended.
+func (b *balancer) remove(be *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, other := range b.backends {
+		if other == be {
+			b.backends = append(b.backends[:i], b.backends[i+1:]...)
+			break
+		}
+	}
+	be.shutdown()
+}
+
+// has reports whether a backend for the pod exists, including draining ones.
+func (b *balancer) has(pod string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, be := range b.backends {
+		if be.pod == pod {
+			return true
+		}
+	}
+	return false
+}
+
+// pods returns the pods of all backends that accept new connections.
+func (b *balancer) pods() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var pods []string
+	for _, be := range b.backends {
+		if !be.draining {
+			pods = append(pods, be.pod)
+		}
+	}
+	return pods
+}
+
+// current returns the first backend that accepts new connections, nil if there is none.
+func (b *balancer) current() *backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, be := range b.backends {
+		if !be.draining {
+			return be
+		}
+	}
+	return nil
+}
+
+// pick selects the backend for a new connection and counts the connection as active, nil if no backend is available.
+func (b *balancer) pick() *backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var candidates []*backend
+	for _, be := range b.backends {
+		if !be.draining {
+			candidates = append(candidates, be)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	var be *backend
+	switch b.strategy {
+	case config.BalanceLeastConn:
+		be = candidates[0]
+		for _, other := range candidates[1:] {
+			if other.active < be.active {
+				be = other
+			}
+		}
+	case config.BalanceRandom:
+		be = candidates[rand.Intn(len(candidates))]
+	default:
+		be = candidates[b.next%len(candidates)]
+		b.next++
+	}
+	be.active++
+	return be
+}
+
+// release marks a connection to the backend as done.
+func (b *balancer) release(be *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	be.active--
+	if be.draining && be.active == 0 {
+		be.shutdown()
+	}
+}
+
+// drain stops new connections to the backend of the pod and returns the number of connections that are still active.
+func (b *balancer) drain(pod string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, be := range b.backends {
+		if be.pod != pod || be.draining {
+			continue
+		}
+		be.draining = true
+		if be.active == 0 {
+			be.shutdown()
+		}
+		return be.active
+	}
+	return 0
+}
+
+// shutdown stops the sessions of all backends.
+func (b *balancer) shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, be := range b.backends {
+		be.shutdown()
+	}
+}
+
+// target is a ready pod, the port to forward to on it and the deployment revision it belongs to, if any.
+type target struct {
+	pod      string
+	port     int32
+	revision int64
+}
+
+// readyTargets lists all ready pods of the deployment or service and the port to forward to on each of them.
+// Pods the port cannot be resolved for are skipped, so they do not keep the others from being synchronized.
+func (fwd *Forwarder) readyTargets() ([]target, error) {
+	if fwd.pods == nil {
+		return nil, fmt.Errorf("pods of %s are not watched", fwd.balancedTarget())
+	}
+	var targets []target
+	switch fwd.Type() {
+	case config.ForwardTypeDeployment:
+		rss, err := fwd.pods.replicaSets.ReplicaSets(fwd.Namespace).List(fwd.pods.selector)
+		if err != nil {
+			return nil, err
+		}
+		revs := revisions(rss)
+		pods, err := fwd.pods.pods.Pods(fwd.Namespace).List(fwd.pods.selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			if podReady(pod) != nil {
+				continue
+			}
+			port, err := fwd.containerPort(pod, fwd.Remote)
+			if err != nil {
+				fwd.Log.WithField("pod", pod.Name).Warnf("skipping pod %s: %v", pod.Name, err)
+				continue
+			}
+			targets = append(targets, target{pod.Name, port, podRevision(pod, revs)})
+		}
+	case config.ForwardTypeService:
+		service, err := fwd.pods.services.Services(fwd.Namespace).Get(fwd.Service)
+		if err != nil {
+			return nil, err
+		}
+		svcPort, err := fwd.lookupServicePort(service)
+		if err != nil {
+			return nil, err
+		}
+		portSpec, err := servicePort(service, svcPort)
+		if err != nil {
+			return nil, err
+		}
+		slices, err := fwd.pods.endpointSlices.EndpointSlices(fwd.Namespace).List(sliceSelector(fwd.Service))
+		if err != nil {
+			return nil, err
+		}
+		for _, ep := range podEndpoints(slices, portSpec) {
+			pod, err := fwd.pods.pods.Pods(fwd.Namespace).Get(ep.pod)
+			if err != nil {
+				fwd.Log.WithField("pod", ep.pod).Warnf("skipping pod %s: %v", ep.pod, err)
+				continue
+			}
+			port, err := fwd.endpointPort(service, pod, ep, svcPort)
+			if err != nil {
+				fwd.Log.WithField("pod", pod.Name).Warnf("skipping pod %s: %v", pod.Name, err)
+				continue
+			}
+			targets = append(targets, target{pod.Name, port, 0})
+		}
+	default:
+		return nil, fmt.Errorf("balancing requires a deployment or service")
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].pod < targets[j].pod })
+	return targets, nil
+}
+
+// startBackend establishes a port-forward session to the target on an internal port and adds it to the balancer.
+func (fwd *Forwarder) startBackend(rc *rest.Config, b *balancer, t target) error {
+	be := &backend{pod: t.pod, port: t.port, stop: make(chan struct{})}
+	ready := make(chan struct{})
+	pf, err := fwd.portForward(rc, t.pod, sessionAddr, fmt.Sprintf("0:%d", t.port), be.stop, ready)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- pf.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("port-forward to pod %s stopped", t.pod)
+		}
+		return err
+	}
+	ports, err := pf.GetPorts()
+	if err != nil {
+		be.shutdown()
+		return err
+	}
+	be.addr = net.JoinHostPort(sessionAddr, strconv.Itoa(int(ports[0].Local)))
+	b.add(be)
+	removeSession := addSession(int32(ports[0].Local), fwd.Log.WithField("pod", be.pod))
+	go func() {
+		err := <-done
+		removeSession()
+		b.remove(be)
+		if err != nil {
+			fwd.Log.WithField("pod", be.pod).Warnf("port-forward to pod %s failed: %v", be.pod, err)
+			fwd.Metrics.Reconnect()
+			// the next sync establishes a new session if the pod is still ready
+			fwd.Events.Emit(events.Event{Type: events.Reconnecting, Forward: fwd.Name, Pod: be.pod, Error: err.Error()})
+		}
+	}()
+	return nil
+}
+
+// syncBackends starts sessions to new ready pods and drains the ones of pods that are gone or no longer ready.
+// Forwards that are not balanced only use a single pod of the newest revision and drain the previous one after rollouts,
+// as long as the new pod could be forwarded to. Their target is updated and Retargeted notified when the pod changes.
+func (fwd *Forwarder) syncBackends(rc *rest.Config, b *balancer) {
+	targets, err := fwd.readyTargets()
+	if err != nil {
+		fwd.Log.Warnf("failed to list ready pods: %v", err)
+		return
+	}
+	ready := make(map[string]bool)
+	for _, t := range targets {
+		ready[t.pod] = true
+	}
+	if fwd.Balance == nil {
+		targets = followTargets(targets, b.pods())
+	}
+	selected := make(map[string]bool)
+	available := false
+	for _, t := range targets {
+		selected[t.pod] = true
+		if b.has(t.pod) {
+			available = true
+			continue
+		}
+		if err := fwd.startBackend(rc, b, t); err != nil {
+			fwd.Log.WithField("pod", t.pod).Warnf("failed to forward to pod %s: %v", t.pod, err)
+			continue
+		}
+		available = true
+		fwd.Log.WithField("pod", t.pod).Infof("forwarding to pod %s:%d", t.pod, t.port)
+	}
+	for _, pod := range b.pods() {
+		if selected[pod] || (ready[pod] && !available) {
+			continue
+		}
+		fwd.Log.WithField("pod", pod).Infof("draining pod %s (%d active connections)", pod, b.drain(pod))
+	}
+	if fwd.Balance != nil {
+		return
+	}
+	if be := b.current(); be != nil && fwd.setTarget(be.pod, be.port) {
+		select {
+		case fwd.Retargeted <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serve accepts connections on the local listener and proxies each of them to a backend.
+func (fwd *Forwarder) serve(l net.Listener, b *balancer) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fwd.Log.Errorf("failed to accept connection: %v", err)
+				fwd.Metrics.Error()
+			}
+			return
+		}
+		go fwd.proxy(fwd.Metrics.Conn(conn), b)
+	}
+}
+
+func (fwd *Forwarder) proxy(conn net.Conn, b *balancer) {
+	defer conn.Close()
+	be := b.pick()
+	if be == nil {
+		fwd.Log.Warnf("no ready pod for connection from %s", conn.RemoteAddr())
+		fwd.Metrics.Error()
+		return
+	}
+	defer b.release(be)
+	upstream, err := net.Dial("tcp", be.addr)
+	if err != nil {
+		fwd.Log.WithField("pod", be.pod).Warnf("failed to connect to pod %s: %v", be.pod, err)
+		fwd.Metrics.Error()
+		return
+	}
+	defer upstream.Close()
+	pipe(conn, upstream)
+}
+
+// serveProxied listens on the local address and proxies connections to port-forwards to the ready pods of the target
+// until the forward is stopped. The pods and EndpointSlices of the target are watched and synchronized periodically,
+// so new pods get connections and the ones that are gone or replaced by a rollout drain. Forwards that are not balanced
+// fail with errCannotFollow if the pods may not be watched or no ready pod could be forwarded to.
+func (fwd *Forwarder) serveProxied(rc *rest.Config, stop chan struct{}) error {
+	changed := make(chan struct{}, 1)
+	// the informers are stopped on every return, including failures before the forward is ready
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var pods *podCache
+	switch fwd.Type() {
+	case config.ForwardTypeDeployment:
+		selector, err := fwd.deploymentSelector()
+		if err != nil {
+			return err
+		}
+		if err := fwd.checkAccess(selector); err != nil {
+			if apierrors.IsForbidden(err) && fwd.Balance == nil {
+				return fmt.Errorf("%w: %v", errCannotFollow, err)
+			}
+			return err
+		}
+		if pods, err = fwd.watchPods(selector, changed, ctx.Done()); err != nil {
+			return err
+		}
+	case config.ForwardTypeService:
+		var err error
+		if pods, err = fwd.watchEndpoints(changed, ctx.Done()); err != nil {
+			return err
+		}
+	}
+	fwd.pods = pods
+	strategy := config.BalanceRoundRobin
+	if fwd.Balance != nil {
+		strategy = *fwd.Balance
+	}
+	b := newBalancer(strategy)
+	defer b.shutdown()
+	fwd.syncBackends(rc, b)
+	if len(b.pods()) == 0 {
+		if fwd.Balance == nil {
+			return fmt.Errorf("%w: no port-forward to any ready pod could be established", errCannotFollow)
+		}
+		return fmt.Errorf("no port-forward to any ready pod could be established")
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(fwd.BindAddr, strconv.Itoa(int(fwd.BindPort))))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fwd.balancer = b
+	close(fwd.Ready)
+	go fwd.serve(l, b)
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-changed:
+			fwd.syncBackends(rc, b)
+		case <-ticker.C:
+			fwd.syncBackends(rc, b)
+		}
+	}
+}
+
+// servePod listens on the local address and proxies connections to a port-forward session to the pod on an internal port,
+// so the connections can be instrumented. Like a direct port-forward, the forward fails once the session is lost.
+func (fwd *Forwarder) servePod(rc *rest.Config, pod string, stop chan struct{}) error {
+	l, err := net.Listen("tcp", net.JoinHostPort(fwd.BindAddr, strconv.Itoa(int(fwd.BindPort))))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	ready := make(chan struct{})
+	pf, err := fwd.portForward(rc, pod, sessionAddr, fmt.Sprintf("0:%d", fwd.TargetPort), stop, ready)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- pf.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-done:
+		return err
+	}
+	ports, err := pf.GetPorts()
+	if err != nil {
+		return err
+	}
+	fwd.session = net.JoinHostPort(sessionAddr, strconv.Itoa(int(ports[0].Local)))
+	defer addSession(int32(ports[0].Local), fwd.Log)()
+	b := newBalancer(config.BalanceRoundRobin)
+	b.add(&backend{pod: pod, port: fwd.TargetPort, addr: fwd.session, stop: make(chan struct{})})
+	close(fwd.Ready)
+	go fwd.serve(l, b)
+	return <-done
+}
+
+// balancedTarget describes the target of a balanced forward, since it is not a single pod.
+func (fwd *Forwarder) balancedTarget() string {
+	if fwd.Type() == config.ForwardTypeDeployment {
+		return "deployment/" + fwd.Deployment
+	}
+	return "service/" + fwd.Service
+}
@@ -0,0 +1,224 @@
+package forwarder
+
+import (
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"github.com/tmsmr/k4wd/internal/pkg/metrics"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mockBalancer(strategy string, pods ...string) *balancer {
+	b := newBalancer(strategy)
+	for _, pod := range pods {
+		b.add(&backend{pod: pod, stop: make(chan struct{})})
+	}
+	return b
+}
+
+func stopped(be *backend) bool {
+	select {
+	case <-be.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBalancer_pick(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		pods     []string
+		// hold keeps the connections of these picks active
+		hold []bool
+		want []string
+	}{
+		{"round-robin", config.BalanceRoundRobin, []string{"b", "a"}, []bool{false, false, false}, []string{"a", "b", "a"}},
+		{"least-conn", config.BalanceLeastConn, []string{"a", "b"}, []bool{true, false, false, true}, []string{"a", "b", "b", "b"}},
+		{"no backends", config.BalanceRoundRobin, nil, []bool{false}, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := mockBalancer(tt.strategy, tt.pods...)
+			var got []string
+			for _, hold := range tt.hold {
+				be := b.pick()
+				if be == nil {
+					got = append(got, "")
+					continue
+				}
+				got = append(got, be.pod)
+				if !hold {
+					b.release(be)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pick() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalancer_pick_random(t *testing.T) {
+	b := mockBalancer(config.BalanceRandom, "a", "b")
+	for i := 0; i < 10; i++ {
+		be := b.pick()
+		if be == nil || (be.pod != "a" && be.pod != "b") {
+			t.Fatalf("pick() = %v, want a or b", be)
+		}
+		b.release(be)
+	}
+}
+
+func TestBalancer_drain(t *testing.T) {
+	b := mockBalancer(config.BalanceRoundRobin, "a", "b")
+	be := b.pick()
+	if active := b.drain("a"); active != 1 {
+		t.Errorf("drain() = %d, want 1", active)
+	}
+	if stopped(be) {
+		t.Errorf("drain() stopped backend with active connections")
+	}
+	if got := b.pods(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("pods() = %v, want [b]", got)
+	}
+	if other := b.pick(); other.pod != "b" {
+		t.Errorf("pick() = %s, want b", other.pod)
+	}
+	b.release(be)
+	if !stopped(be) {
+		t.Errorf("release() did not stop drained backend")
+	}
+	if !b.has("a") {
+		t.Errorf("has() = false, drained backend is removed when its session ends")
+	}
+	b.remove(be)
+	if b.has("a") {
+		t.Errorf("has() = true after remove()")
+	}
+}
+
+func TestForwarder_readyTargets(t *testing.T) {
+	ns := "k4wd"
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: ns},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}},
+	}
+	pending := httpPod("b", 8080)
+	pending.Status.Phase = v1.PodPending
+	cs := fake.NewSimpleClientset(
+		deployment,
+		mockReplicaSet("app-1", 1),
+		mockReplicaSet("app-2", 2),
+		ownedBy(httpPod("c", 8080), "app-2"),
+		ownedBy(httpPod("a", 9090), "app-1"),
+		ownedBy(pending, "app-2"),
+		ownedBy(mockPod("d", v1.PodRunning, true), "app-2"),
+	)
+	fwd := &Forwarder{Forward: config.Forward{Deployment: "app", Remote: "http"}, Namespace: ns, Clients: cs, Log: discardLog()}
+	if _, err := fwd.readyTargets(); err == nil {
+		t.Errorf("readyTargets() error = nil, want error without watched pods")
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	pods, err := fwd.watchPods(labels.SelectorFromSet(map[string]string{"app": "app"}), make(chan struct{}, 1), stop)
+	if err != nil {
+		t.Fatalf("watchPods() error = %v", err)
+	}
+	fwd.pods = pods
+	got, err := fwd.readyTargets()
+	if err != nil {
+		t.Fatalf("readyTargets() error = %v", err)
+	}
+	want := []target{{"a", 9090, 1}, {"c", 8080, 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readyTargets() = %v, want %v", got, want)
+	}
+}
+
+// mockPodServer accepts connections and replies with the name of the pod.
+func mockPodServer(t *testing.T, pod string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(pod))
+			_ = conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestForwarder_serve(t *testing.T) {
+	b := newBalancer(config.BalanceRoundRobin)
+	for _, pod := range []string{"a", "b"} {
+		b.add(&backend{pod: pod, addr: mockPodServer(t, pod), stop: make(chan struct{})})
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fwd := &Forwarder{Log: discardLog()}
+	go fwd.serve(l, b)
+	var got []string
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("serve() proxied to %v, want %v", got, want)
+	}
+}
+
+func TestForwarder_serve_metrics(t *testing.T) {
+	b := newBalancer(config.BalanceRoundRobin)
+	b.add(&backend{pod: "a", addr: mockPodServer(t, "a"), stop: make(chan struct{})})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m := metrics.New()
+	fwd := &Forwarder{Name: "test", Log: discardLog(), Metrics: m.Forward("test")}
+	go fwd.serve(l, b)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", metrics.Path, nil))
+	for _, want := range []string{`k4wd_connections_total{forward="test"} 1`, `k4wd_bytes_out_total{forward="test"} 1`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"sort"
+)
+
+// endpoint is a ready pod backing a service and the port the service port is routed to on it, 0 if unknown.
+type endpoint struct {
+	pod  string
+	port int32
+}
+
+// servicePort returns the spec of the service port with the given number.
+func servicePort(service *v1.Service, port int32) (*v1.ServicePort, error) {
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Port == port {
+			return &service.Spec.Ports[i], nil
+		}
+	}
+	return nil, fmt.Errorf("service %s does not have port %d", service.Name, port)
+}
+
+// readyEndpoints lists the ready endpoints of the service that are backed by pods, sorted by pod name.
+// EndpointSlices are used instead of the selector, so services with manually managed endpoints work as well.
+func (fwd *Forwarder) readyEndpoints(service *v1.Service, portSpec *v1.ServicePort) ([]endpoint, error) {
+	slices, err := fwd.Clients.DiscoveryV1().EndpointSlices(fwd.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: sliceSelector(service.Name).String()})
+	if err != nil {
+		return nil, err
+	}
+	var items []*discoveryv1.EndpointSlice
+	for i := range slices.Items {
+		items = append(items, &slices.Items[i])
+	}
+	return podEndpoints(items, portSpec), nil
+}
+
+// sliceSelector selects the EndpointSlices of the service.
+func sliceSelector(service string) labels.Selector {
+	return labels.SelectorFromSet(map[string]string{discoveryv1.LabelServiceName: service})
+}
+
+// podEndpoints returns the ready endpoints in the slices that are backed by pods, sorted by pod name.
+func podEndpoints(slices []*discoveryv1.EndpointSlice, portSpec *v1.ServicePort) []endpoint {
+	seen := make(map[string]bool)
+	var endpoints []endpoint
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		var port int32
+		for _, p := range slice.Ports {
+			if p.Name != nil && *p.Name == portSpec.Name && p.Port != nil {
+				port = *p.Port
+			}
+		}
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			if ep.TargetRef == nil || ep.TargetRef.Kind != "Pod" || seen[ep.TargetRef.Name] {
+				continue
+			}
+			seen[ep.TargetRef.Name] = true
+			endpoints = append(endpoints, endpoint{pod: ep.TargetRef.Name, port: port})
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].pod < endpoints[j].pod })
+	return endpoints
+}
+
+// watchEndpoints caches the service, its EndpointSlices and the pods it may route to and notifies changed whenever
+// one of them is added, updated or deleted, until stop is closed. Pods of services without a selector are not known
+// in advance, so all pods of the namespace are cached for them.
+func (fwd *Forwarder) watchEndpoints(changed chan<- struct{}, stop <-chan struct{}) (*podCache, error) {
+	service, err := fwd.Clients.CoreV1().Services(fwd.Namespace).Get(context.TODO(), fwd.Service, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.Everything()
+	if len(service.Spec.Selector) > 0 {
+		selector = labels.SelectorFromSet(service.Spec.Selector)
+	}
+	if err := fwd.checkEndpointsAccess(selector); err != nil {
+		return nil, err
+	}
+	serviceFactory := fwd.informerFactory(func(opts *metav1.ListOptions) {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", fwd.Service).String()
+	})
+	sliceFactory := fwd.informerFactory(func(opts *metav1.ListOptions) {
+		opts.LabelSelector = sliceSelector(fwd.Service).String()
+	})
+	podFactory := fwd.informerFactory(func(opts *metav1.ListOptions) {
+		opts.LabelSelector = selector.String()
+	})
+	services := serviceFactory.Core().V1().Services()
+	slices := sliceFactory.Discovery().V1().EndpointSlices()
+	pods := podFactory.Core().V1().Pods()
+	factories := []informers.SharedInformerFactory{serviceFactory, sliceFactory, podFactory}
+	if err := startInformers(changed, stop, factories, services.Informer(), slices.Informer(), pods.Informer()); err != nil {
+		return nil, err
+	}
+	return &podCache{selector: selector, pods: pods.Lister(), services: services.Lister(), endpointSlices: slices.Lister()}, nil
+}
+
+// checkEndpointsAccess lists the EndpointSlices and pods once, so missing permissions are reported right away
+// instead of the informers retrying forever.
+func (fwd *Forwarder) checkEndpointsAccess(selector labels.Selector) error {
+	opts := metav1.ListOptions{LabelSelector: sliceSelector(fwd.Service).String(), Limit: 1}
+	if _, err := fwd.Clients.DiscoveryV1().EndpointSlices(fwd.Namespace).List(context.TODO(), opts); err != nil {
+		return err
+	}
+	_, err := fwd.Clients.CoreV1().Pods(fwd.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String(), Limit: 1})
+	return err
+}
+
+// endpointPort resolves the container port of an endpoint. Ports of services with a selector are mapped on the pod itself,
+// since named targetPorts may differ per pod and the container setting applies. Manually managed endpoints define the port.
+func (fwd *Forwarder) endpointPort(service *v1.Service, pod *v1.Pod, ep endpoint, svcPort int32) (int32, error) {
+	if len(service.Spec.Selector) == 0 && ep.port != 0 {
+		return ep.port, nil
+	}
+	return fwd.servicePortTarget(service, pod, svcPort)
+}
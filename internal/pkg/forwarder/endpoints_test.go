@@ -0,0 +1,148 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func httpPod(name string, port int32) *v1.Pod {
+	pod := mockPod(name, v1.PodRunning, true)
+	pod.Spec.Containers = []v1.Container{{Name: "app", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: port}}}}
+	return pod
+}
+
+// endpointSlice creates a slice of the service with a single port and the given pods and readiness.
+func endpointSlice(service string, portName string, port int32, ready map[string]bool) *discoveryv1.EndpointSlice {
+	slice := mockEndpointSlice(service)
+	slice.Name = service + "-" + portName
+	slice.Ports = []discoveryv1.EndpointPort{{Name: &portName, Port: &port}}
+	for pod, r := range ready {
+		r := r
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{"10.0.0.1"},
+			Conditions: discoveryv1.EndpointConditions{Ready: &r},
+			TargetRef:  &v1.ObjectReference{Kind: "Pod", Name: pod},
+		})
+	}
+	return slice
+}
+
+func TestForwarder_resolveServiceTarget(t *testing.T) {
+	ns := "k4wd"
+	web := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: ns},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"app": "app"},
+			Ports:    []v1.ServicePort{{Name: "web", Port: 80, TargetPort: intstr.FromString("http")}},
+		},
+	}
+	manual := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: ns},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 5432}}},
+	}
+	fqdn := mockEndpointSlice("web")
+	fqdn.Name = "web-fqdn"
+	fqdn.AddressType = discoveryv1.AddressTypeFQDN
+	fqdn.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"example.com"}, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "a"}}}
+	tests := []struct {
+		name         string
+		service      string
+		remote       string
+		objects      []runtime.Object
+		wantPod      string
+		wantPort     int32
+		wantNotReady bool
+		wantErr      bool
+	}{
+		{"ready pod", "web", "80", []runtime.Object{httpPod("a", 8080), httpPod("b", 9090), endpointSlice("web", "web", 8080, map[string]bool{"a": false, "b": true})}, "b", 9090, false, false},
+		{"first ready pod", "web", "web", []runtime.Object{httpPod("a", 8080), httpPod("b", 9090), endpointSlice("web", "web", 8080, map[string]bool{"a": true, "b": true})}, "a", 8080, false, false},
+		{"no ready endpoints", "web", "80", []runtime.Object{httpPod("a", 8080), endpointSlice("web", "web", 8080, map[string]bool{"a": false})}, "", 0, true, true},
+		{"fqdn endpoints", "web", "80", []runtime.Object{httpPod("a", 8080), fqdn}, "", 0, true, true},
+		{"unknown service port", "web", "81", []runtime.Object{httpPod("a", 8080), endpointSlice("web", "web", 8080, map[string]bool{"a": true})}, "", 0, false, true},
+		{"manual endpoints", "db", "5432", []runtime.Object{mockPod("db-0", v1.PodRunning, true), endpointSlice("db", "", 15432, map[string]bool{"db-0": true})}, "db-0", 15432, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(append([]runtime.Object{web, manual}, tt.objects...)...)
+			fwd := &Forwarder{Forward: config.Forward{Service: tt.service, Remote: tt.remote}, Namespace: ns, Clients: cs}
+			pod, port, err := fwd.resolveServiceTarget()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveServiceTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errNotReady) != tt.wantNotReady {
+				t.Errorf("resolveServiceTarget() error = %v, want not ready %v", err, tt.wantNotReady)
+			}
+			if err != nil {
+				return
+			}
+			if pod.Name != tt.wantPod || port != tt.wantPort {
+				t.Errorf("resolveServiceTarget() = %s:%d, want %s:%d", pod.Name, port, tt.wantPod, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestForwarder_readyTargets_service(t *testing.T) {
+	ns := "k4wd"
+	web := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: ns},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"app": "app"},
+			Ports:    []v1.ServicePort{{Name: "web", Port: 80, TargetPort: intstr.FromString("http")}},
+		},
+	}
+	// c does not declare the named port and d is gone, both are skipped
+	cs := fake.NewSimpleClientset(web, httpPod("a", 8080), httpPod("b", 9090), mockPod("c", v1.PodRunning, true),
+		endpointSlice("web", "web", 8080, map[string]bool{"a": true, "b": true, "c": true, "d": true}))
+	balance := config.BalanceRoundRobin
+	fwd := &Forwarder{Forward: config.Forward{Service: "web", Remote: "80", Balance: &balance}, Namespace: ns, Clients: cs, Log: discardLog()}
+	if _, err := fwd.readyTargets(); err == nil {
+		t.Errorf("readyTargets() error = nil, want error without watched pods")
+	}
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	pods, err := fwd.watchEndpoints(changed, stop)
+	if err != nil {
+		t.Fatalf("watchEndpoints() error = %v", err)
+	}
+	fwd.pods = pods
+	got, err := fwd.readyTargets()
+	if err != nil {
+		t.Fatalf("readyTargets() error = %v", err)
+	}
+	want := []target{{"a", 8080, 0}, {"b", 9090, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readyTargets() = %v, want %v", got, want)
+	}
+	<-changed
+	if _, err := cs.CoreV1().Pods(ns).Create(context.TODO(), httpPod("d", 7070), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watchEndpoints() did not notify about new pod")
+	}
+	// notifications about the initial list may still be pending, so wait for the new pod to be listed
+	deadline := time.Now().Add(5 * time.Second)
+	for len(got) != 3 && time.Now().Before(deadline) {
+		if got, err = fwd.readyTargets(); err != nil {
+			t.Fatalf("readyTargets() error = %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) != 3 {
+		t.Errorf("readyTargets() = %v, want new pod d", got)
+	}
+}
@@ -3,39 +3,50 @@ package forwarder
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"github.com/tmsmr/k4wd/internal/pkg/events"
 	"github.com/tmsmr/k4wd/internal/pkg/kubeclient"
-	"io"
+	"github.com/tmsmr/k4wd/internal/pkg/metrics"
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
 	"k8s.io/kubectl/pkg/polymorphichelpers"
 	"k8s.io/kubectl/pkg/util"
 	"k8s.io/kubectl/pkg/util/podutils"
-	"net/http"
-	"os"
 	"sort"
-	"strconv"
+	"sync"
 	"time"
 )
 
 const (
 	defaultNamespace     = "default"
-	defaultBindAddr      = "127.0.0.1"
 	podBySelectorTimeout = 5 * time.Second
+	defaultCheckTimeout  = 10 * time.Second
+	defaultDialTimeout   = 30 * time.Second
+	probeTimeout         = time.Second
 )
 
 type Forwarder struct {
 	Name string
 	config.Forward
-	Clients *kubernetes.Clientset
+	Clients kubernetes.Interface
 	Io      genericiooptions.IOStreams
 	Ready   chan struct{}
+	// Retargeted is notified when a forward following rollouts switched to another pod
+	Retargeted chan struct{}
+	Log        *log.Entry
+	// Events receives the reconnecting events of the forward, nil if not needed
+	Events *events.Emitter
+	// Metrics records the connections accepted on the local address, nil if not needed
+	Metrics *metrics.Forward
 
 	Namespace  string
 	BindAddr   string
@@ -43,18 +54,31 @@ type Forwarder struct {
 	RandPort   bool
 	TargetPod  string
 	TargetPort int32
+	Declared   bool
+	Listening  *bool
+
+	// mu guards BindPort once a random port is chosen and TargetPod and TargetPort once the forward follows rollouts
+	mu       sync.Mutex
+	watches  kubernetes.Interface
+	balancer *balancer
+	pods     *podCache
+	// session is the internal address of the port-forward session connections to a single pod are proxied to, if any
+	session string
 }
 
-func New(name string, spec config.Forward, stdout io.Writer) (*Forwarder, error) {
+// New creates the Forwarder for the forward. The output of the portforward library is logged with the fields of the forward,
+// progress at debug and errors at warning level.
+func New(name string, spec config.Forward) (*Forwarder, error) {
 	fwd := &Forwarder{
-		Name:    name,
-		Forward: spec,
-		Io: genericiooptions.IOStreams{
-			In:     &bytes.Buffer{},
-			Out:    stdout,
-			ErrOut: os.Stderr,
-		},
-		Ready: make(chan struct{}),
+		Name:       name,
+		Forward:    spec,
+		Ready:      make(chan struct{}),
+		Retargeted: make(chan struct{}, 1),
+	}
+	fwd.Io = genericiooptions.IOStreams{
+		In:     &bytes.Buffer{},
+		Out:    &lineLogger{log: func(line string) { fwd.Log.Debug(line) }},
+		ErrOut: &lineLogger{log: func(line string) { fwd.Log.Warn(line) }},
 	}
 
 	if spec.Namespace != nil {
@@ -62,15 +86,17 @@ func New(name string, spec config.Forward, stdout io.Writer) (*Forwarder, error)
 	} else {
 		fwd.Namespace = defaultNamespace
 	}
+	fields := log.Fields{"forward": name, "namespace": fwd.Namespace}
+	if spec.Context != nil {
+		fields["context"] = *spec.Context
+	}
+	fwd.Log = log.WithFields(fields)
 
-	addr, port, err := spec.LocalAddr()
+	addr, port, err := spec.BindAddr()
 	if err != nil {
 		return nil, err
 	}
 	fwd.BindAddr = addr
-	if fwd.BindAddr == "" {
-		fwd.BindAddr = defaultBindAddr
-	}
 	fwd.BindPort = port
 	if fwd.BindPort == 0 {
 		fwd.RandPort = true
@@ -84,7 +110,33 @@ func (fwd *Forwarder) String() string {
 	if fwd.Context != nil {
 		ns = fmt.Sprintf("%s/%s", *fwd.Context, ns)
 	}
-	return fmt.Sprintf("%s:%d -> %s%s:%d", fwd.BindAddr, fwd.BindPort, ns, fwd.TargetPod, fwd.TargetPort)
+	addr, bindPort := fwd.Bind()
+	pod, port := fwd.Target()
+	return fmt.Sprintf("%s:%d -> %s%s:%d", addr, bindPort, ns, pod, port)
+}
+
+// Bind returns the local address and port of the forward. A random port is only known once the forward is ready.
+func (fwd *Forwarder) Bind() (string, int32) {
+	fwd.mu.Lock()
+	defer fwd.mu.Unlock()
+	return fwd.BindAddr, fwd.BindPort
+}
+
+// Target returns the pod and port the forward currently forwards to.
+func (fwd *Forwarder) Target() (string, int32) {
+	fwd.mu.Lock()
+	defer fwd.mu.Unlock()
+	return fwd.TargetPod, fwd.TargetPort
+}
+
+// setTarget updates the pod and port the forward forwards to and reports whether they changed.
+func (fwd *Forwarder) setTarget(pod string, port int32) bool {
+	fwd.mu.Lock()
+	defer fwd.mu.Unlock()
+	changed := fwd.TargetPod != pod || fwd.TargetPort != port
+	fwd.TargetPod = pod
+	fwd.TargetPort = port
+	return changed
 }
 
 // resolvePodTarget looks up a pod by name and resolves the target port.
@@ -94,33 +146,30 @@ func (fwd *Forwarder) resolvePodTarget(name string) (*v1.Pod, int32, error) {
 		return nil, 0, err
 	}
 
-	var podPort int32
-	val, err := strconv.Atoi(fwd.Remote)
-	if err == nil {
-		if val < 1 || val > 65535 {
-			return nil, 0, fmt.Errorf("invalid port number: %d", val)
-		}
-		podPort = int32(val)
-	} else {
-		podPort, err = util.LookupContainerPortNumberByName(*pod, fwd.Remote)
-		if err != nil {
-			return nil, 0, err
-		}
+	podPort, err := fwd.containerPort(pod, fwd.Remote)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return pod, podPort, nil
 }
 
 // resolveDeploymentTarget looks up the deployment, finds a matching pod and resolves the target port.
-func (fwd *Forwarder) resolveDeploymentTarget() (*v1.Pod, int32, error) {
-	deployment, err := fwd.Clients.AppsV1().Deployments(fwd.Namespace).Get(context.TODO(), fwd.Deployment, metav1.GetOptions{})
+// While waiting, the pods are only listed instead of waiting for one to be created, since changes to them are watched
+// and the wait has to end as soon as the forward is stopped or the deadline passes.
+func (fwd *Forwarder) resolveDeploymentTarget(waiting bool) (*v1.Pod, int32, error) {
+	selector, err := fwd.deploymentSelector()
 	if err != nil {
 		return nil, 0, err
 	}
 
-	selector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels)
 	sorter := func(pods []*v1.Pod) sort.Interface { return sort.Reverse(podutils.ActivePods(pods)) }
-	pod, _, err := polymorphichelpers.GetFirstPod(fwd.Clients.CoreV1(), fwd.Namespace, selector.String(), podBySelectorTimeout, sorter)
+	var pod *v1.Pod
+	if waiting {
+		pod, err = fwd.firstPod(selector, sorter)
+	} else {
+		pod, _, err = polymorphichelpers.GetFirstPod(fwd.Clients.CoreV1(), fwd.Namespace, selector.String(), podBySelectorTimeout, sorter)
+	}
 	if err != nil {
 		return nil, 0, err
 	}
@@ -128,123 +177,262 @@ func (fwd *Forwarder) resolveDeploymentTarget() (*v1.Pod, int32, error) {
 	return fwd.resolvePodTarget(pod.Name)
 }
 
-// resolveServiceTarget looks up the service, finds a matching pod and resolves the target port.
+// firstPod lists the pods matching the selector once and returns the first one in the order of sorter.
+func (fwd *Forwarder) firstPod(selector labels.Selector, sorter func([]*v1.Pod) sort.Interface) (*v1.Pod, error) {
+	list, err := fwd.Clients.CoreV1().Pods(fwd.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, fmt.Errorf("%w: deployment %s has no pods", errNotReady, fwd.Deployment)
+	}
+	pods := make([]*v1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pods = append(pods, &list.Items[i])
+	}
+	sort.Sort(sorter(pods))
+	return pods[0], nil
+}
+
+// lookupServicePort resolves the remote port of the forward, either a number or a port name, in the service.
+func (fwd *Forwarder) lookupServicePort(service *v1.Service) (int32, error) {
+	svcPort, ok, err := portNumber(fwd.Remote)
+	if err != nil || ok {
+		return svcPort, err
+	}
+	return util.LookupServicePortNumberByName(*service, fwd.Remote)
+}
+
+// resolveServiceTarget looks up the service, picks a pod from its ready endpoints and resolves the target port.
 func (fwd *Forwarder) resolveServiceTarget() (*v1.Pod, int32, error) {
 	service, err := fwd.Clients.CoreV1().Services(fwd.Namespace).Get(context.TODO(), fwd.Service, metav1.GetOptions{})
 	if err != nil {
 		return nil, 0, err
 	}
 
-	var svcPort int32
-	val, err := strconv.Atoi(fwd.Remote)
-	if err == nil {
-		if val < 1 || val > 65535 {
-			return nil, 0, fmt.Errorf("invalid port number: %d", val)
-		}
-		svcPort = int32(val)
-	} else {
-		svcPort, err = util.LookupServicePortNumberByName(*service, fwd.Remote)
-		if err != nil {
-			return nil, 0, err
-		}
+	svcPort, err := fwd.lookupServicePort(service)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	selector := labels.SelectorFromSet(service.Spec.Selector)
-	sorter := func(pods []*v1.Pod) sort.Interface { return sort.Reverse(podutils.ActivePods(pods)) }
-	pod, _, err := polymorphichelpers.GetFirstPod(fwd.Clients.CoreV1(), fwd.Namespace, selector.String(), podBySelectorTimeout, sorter)
+	portSpec, err := servicePort(service, svcPort)
 	if err != nil {
 		return nil, 0, err
 	}
-
-	targetPort, err := util.LookupContainerPortNumberByServicePort(*service, *pod, svcPort)
+	endpoints, err := fwd.readyEndpoints(service, portSpec)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(endpoints) == 0 {
+		return nil, 0, fmt.Errorf("%w: service %s has no ready endpoints backed by pods", errNotReady, service.Name)
+	}
+	pod, err := fwd.Clients.CoreV1().Pods(fwd.Namespace).Get(context.TODO(), endpoints[0].pod, metav1.GetOptions{})
 	if err != nil {
 		return nil, 0, err
 	}
-	return pod, targetPort, nil
-}
 
-// Run starts the port forwarding and blocks until it is stopped.
-func (fwd *Forwarder) Run(kc *kubeclient.Kubeclient, stop chan struct{}) error {
-	cs, err := kc.Clientset(fwd.Context, nil)
+	targetPort, err := fwd.endpointPort(service, pod, endpoints[0], svcPort)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	fwd.Clients = cs
+	return pod, targetPort, nil
+}
 
+// resolveTarget resolves the target pod and port based on the forward type, see resolveDeploymentTarget for waiting.
+func (fwd *Forwarder) resolveTarget(waiting bool) (*v1.Pod, int32, error) {
 	// TODO: make this more generic, compare portforward.go in kubectl
-	// resolve target pod and port based on forward type
-	var pod *v1.Pod
-	var port int32
 	switch fwd.Type() {
 	case config.ForwardTypePod:
-		pod, port, err = fwd.resolvePodTarget(fwd.Pod)
-		break
+		return fwd.resolvePodTarget(fwd.Pod)
 	case config.ForwardTypeDeployment:
-		pod, port, err = fwd.resolveDeploymentTarget()
-		break
+		return fwd.resolveDeploymentTarget(waiting)
 	case config.ForwardTypeService:
-		pod, port, err = fwd.resolveServiceTarget()
-		break
+		return fwd.resolveServiceTarget()
 	default:
-		return fmt.Errorf("unsupported forward type: %d", fwd.Type())
+		return nil, 0, fmt.Errorf("unsupported forward type: %d", fwd.Type())
+	}
+}
+
+// overrides maps the client settings of the forward to overrides of the Kubeclient configuration.
+func (fwd *Forwarder) overrides() []kubeclient.ConfigOverride {
+	var opts []kubeclient.ConfigOverride
+	if fwd.Kubeconfig != nil && *fwd.Kubeconfig != "" {
+		opts = append(opts, kubeclient.WithKubeconfigFile(*fwd.Kubeconfig))
+	}
+	if fwd.As != nil && *fwd.As != "" {
+		opts = append(opts, kubeclient.WithImpersonation(*fwd.As, fwd.AsGroups))
+	}
+	if fwd.Server != nil && *fwd.Server != "" {
+		opts = append(opts, kubeclient.WithServer(*fwd.Server))
+	}
+	if fwd.ProxyURL != nil && *fwd.ProxyURL != "" {
+		opts = append(opts, kubeclient.WithProxyURL(*fwd.ProxyURL))
+	}
+	if fwd.TLSServerName != nil && *fwd.TLSServerName != "" {
+		opts = append(opts, kubeclient.WithTLSServerName(*fwd.TLSServerName))
 	}
+	if fwd.InsecureSkipTLSVerify != nil {
+		opts = append(opts, kubeclient.WithInsecureSkipTLSVerify(*fwd.InsecureSkipTLSVerify))
+	}
+	if fwd.QPS != nil || fwd.Burst != nil {
+		var qps float32
+		var burst int
+		if fwd.QPS != nil {
+			qps = *fwd.QPS
+		}
+		if fwd.Burst != nil {
+			burst = *fwd.Burst
+		}
+		opts = append(opts, kubeclient.WithRateLimits(qps, burst))
+	}
+	if fwd.Timeout != nil {
+		opts = append(opts, kubeclient.WithTimeout(*fwd.Timeout))
+	}
+	opts = append(opts, kubeclient.WithDialTimeout(fwd.dialTimeout()))
+	return opts
+}
+
+func (fwd *Forwarder) dialTimeout() time.Duration {
+	if fwd.DialTimeout != nil && *fwd.DialTimeout > 0 {
+		return *fwd.DialTimeout
+	}
+	return defaultDialTimeout
+}
+
+// newClients creates the clients of the forward. Watches use separate clients without the request timeout,
+// since it would cut them off after the timeout as well.
+func (fwd *Forwarder) newClients(kc *kubeclient.Kubeclient, rc *rest.Config) error {
+	cs, err := kc.Clientset(nil, rc)
+	if err != nil {
+		return err
+	}
+	fwd.Clients = cs
+	wc := rest.CopyConfig(rc)
+	wc.Timeout = 0
+	watches, err := kc.Clientset(nil, wc)
 	if err != nil {
 		return err
 	}
+	fwd.watches = watches
+	return nil
+}
 
-	// try to find unsupported protocols
-	for _, cont := range pod.Spec.Containers {
-		for _, portSpec := range cont.Ports {
-			if portSpec.ContainerPort != port {
-				continue
-			}
-			if portSpec.Protocol != v1.ProtocolTCP {
-				return fmt.Errorf("unsupported protocol: %s", portSpec.Protocol)
-			}
-		}
+// watchClients returns the clients used for watches, the regular ones if no separate ones were created.
+func (fwd *Forwarder) watchClients() kubernetes.Interface {
+	if fwd.watches != nil {
+		return fwd.watches
 	}
+	return fwd.Clients
+}
 
-	fwd.TargetPod = pod.Name
-	fwd.TargetPort = port
+// checkServer verifies that the API server is reachable, so a hung or unreachable server fails fast with a clear error.
+func (fwd *Forwarder) checkServer(rc *rest.Config) error {
+	timeout := defaultCheckTimeout
+	if fwd.Timeout != nil && *fwd.Timeout > 0 {
+		timeout = *fwd.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := fwd.Clients.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+	if err == nil {
+		return nil
+	}
+	kubecontext := "current context"
+	if fwd.Context != nil {
+		kubecontext = fmt.Sprintf("context %s", *fwd.Context)
+	}
+	if apierrors.IsUnauthorized(err) {
+		return fmt.Errorf("API server %s (%s) rejected the credentials: %v", rc.Host, kubecontext, err)
+	}
+	if _, ok := err.(apierrors.APIStatus); ok {
+		// the server responded, permissions for the targets are checked when resolving them
+		return nil
+	}
+	return fmt.Errorf("API server %s (%s) unreachable: %v", rc.Host, kubecontext, err)
+}
+
+// Run starts the port forwarding and blocks until it is stopped.
+func (fwd *Forwarder) Run(kc *kubeclient.Kubeclient, stop chan struct{}) error {
+	rc, err := kc.RESTConfig(fwd.Context, fwd.overrides()...)
+	if err != nil {
+		return err
+	}
+	if err := fwd.newClients(kc, rc); err != nil {
+		return err
+	}
+	if err := fwd.checkServer(rc); err != nil {
+		return err
+	}
+
+	pod, port, err := fwd.awaitTarget(stop)
+	if err != nil {
+		return err
+	}
+
+	// try to find unsupported protocols and undeclared ports
+	declared, err := fwd.checkPort(pod, port)
+	if err != nil {
+		return err
+	}
+	if !declared && fwd.undeclaredPolicy() == config.UndeclaredStrict {
+		return fmt.Errorf("port %d is not declared in pod %s", port, pod.Name)
+	}
+	fwd.Declared = declared
+
+	fwd.setTarget(pod.Name, port)
 	if fwd.RandPort {
 		local, err := randomLocalPort()
 		if err != nil {
 			return err
 		}
+		fwd.mu.Lock()
 		fwd.BindPort = int32(local)
+		fwd.mu.Unlock()
 	}
 
-	// ensure pod is running
-	if pod.Status.Phase != v1.PodRunning {
-		return fmt.Errorf("target pod not running: %s", fwd.TargetPod)
+	if fwd.Balance != nil && fwd.Type() != config.ForwardTypePod {
+		fwd.setTarget(fwd.balancedTarget(), port)
+		return fwd.serveProxied(rc, stop)
+	}
+	if fwd.Type() == config.ForwardTypeDeployment {
+		err := fwd.serveProxied(rc, stop)
+		if !errors.Is(err, errCannotFollow) {
+			return err
+		}
+		// the pod only has to be running, like without following rollouts
+		fwd.Log.Warnf("forwarding to pod %s only, %v", pod.Name, err)
 	}
 
 	// start forwarding
-	rc, err := kc.RESTConfig(fwd.Context)
-	if err != nil {
-		return err
+	fwd.Log = fwd.Log.WithField("pod", pod.Name)
+	if fwd.Metrics != nil {
+		return fwd.servePod(rc, pod.Name, stop)
 	}
-	transport, upgrader, err := spdy.RoundTripperFor(rc)
+	forwarder, err := fwd.portForward(rc, pod.Name, fwd.BindAddr, fmt.Sprintf("%d:%d", fwd.BindPort, fwd.TargetPort), stop, fwd.Ready)
 	if err != nil {
 		return err
 	}
+	defer addSession(fwd.BindPort, fwd.Log)()
+	return forwarder.ForwardPorts()
+}
+
+// portForward prepares a port-forward session to the pod, listening on addr with the local:remote port mapping.
+func (fwd *Forwarder) portForward(rc *rest.Config, pod, addr, ports string, stop, ready chan struct{}) (*portforward.PortForwarder, error) {
 	req := fwd.Clients.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Namespace(fwd.Namespace).
-		Name(pod.Name).
+		Name(pod).
 		SubResource("portforward")
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
-	forwarder, err := portforward.NewOnAddresses(
+	dialer, err := fwd.dialer(rc, req.URL())
+	if err != nil {
+		return nil, err
+	}
+	return portforward.NewOnAddresses(
 		dialer,
-		[]string{fwd.BindAddr},
-		[]string{fmt.Sprintf("%d:%d", fwd.BindPort, fwd.TargetPort)},
+		[]string{addr},
+		[]string{ports},
 		stop,
-		fwd.Ready,
+		ready,
 		fwd.Io.Out,
 		fwd.Io.ErrOut,
 	)
-	if err != nil {
-		return err
-	}
-	return forwarder.ForwardPorts()
 }
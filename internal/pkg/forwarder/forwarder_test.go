@@ -1,11 +1,18 @@
 package forwarder
 
 import (
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/tmsmr/k4wd/internal/pkg/config"
 	"github.com/tmsmr/k4wd/internal/pkg/kubeclient"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd/api"
-	"os"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -19,13 +26,13 @@ func TestNew(t *testing.T) {
 		want    *Forwarder
 		wantErr bool
 	}{
-		{"minimal pod forward", args{"name", config.Forward{Pod: "pod", Remote: "http-alt"}}, &Forwarder{Namespace: defaultNamespace, BindAddr: defaultBindAddr, BindPort: 0, RandPort: true}, false},
-		{"pod forward with namespace", args{"name", config.Forward{Namespace: func() *string { s := "namespace"; return &s }(), Pod: "pod", Remote: "http-alt"}}, &Forwarder{Namespace: "namespace", BindAddr: defaultBindAddr, BindPort: 0, RandPort: true}, false},
-		{"pod forward with invalid local", args{"name", config.Forward{Local: defaultBindAddr}}, nil, true},
+		{"minimal pod forward", args{"name", config.Forward{Pod: "pod", Remote: "http-alt"}}, &Forwarder{Namespace: defaultNamespace, BindAddr: config.DefaultBindAddr, BindPort: 0, RandPort: true}, false},
+		{"pod forward with namespace", args{"name", config.Forward{Namespace: func() *string { s := "namespace"; return &s }(), Pod: "pod", Remote: "http-alt"}}, &Forwarder{Namespace: "namespace", BindAddr: config.DefaultBindAddr, BindPort: 0, RandPort: true}, false},
+		{"pod forward with invalid local", args{"name", config.Forward{Local: config.DefaultBindAddr}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := New(tt.args.name, tt.args.spec, os.Stdout)
+			got, err := New(tt.args.name, tt.args.spec)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -49,6 +56,129 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_logging(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(level)
+	kubecontext, namespace := "kind", "k4wd"
+	fwd, err := New("db", config.Forward{Context: &kubecontext, Namespace: &namespace, Pod: "postgres-0", Remote: "5432"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fwd.Io.Out.Write([]byte("Handling connection for 5432\n"))
+	_, _ = fwd.Io.ErrOut.Write([]byte("Unable to listen on port 5432\n"))
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d entries, want 2", len(entries))
+	}
+	wantFields := log.Fields{"forward": "db", "namespace": "k4wd", "context": "kind"}
+	for i, level := range []log.Level{log.DebugLevel, log.WarnLevel} {
+		if entries[i].Level != level {
+			t.Errorf("entry %d level = %v, want %v", i, entries[i].Level, level)
+		}
+		if !reflect.DeepEqual(entries[i].Data, wantFields) {
+			t.Errorf("entry %d fields = %v, want %v", i, entries[i].Data, wantFields)
+		}
+	}
+}
+
+func TestForwarder_overrides(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		spec config.Forward
+		want int
+	}{
+		{"none", config.Forward{}, 1},
+		{"empty", config.Forward{Kubeconfig: str(""), As: str("")}, 1},
+		{"kubeconfig", config.Forward{Kubeconfig: str("config")}, 2},
+		{"kubeconfig and impersonation", config.Forward{Kubeconfig: str("config"), As: str("user"), AsGroups: []string{"group"}}, 3},
+		{"cluster", config.Forward{Server: str("https://bastion:6443"), ProxyURL: str("http://proxy:3128"), TLSServerName: str("kubernetes"), InsecureSkipTLSVerify: func() *bool { b := false; return &b }()}, 5},
+		{"client", config.Forward{QPS: func() *float32 { f := float32(50); return &f }(), Timeout: func() *time.Duration { d := time.Second; return &d }()}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &Forwarder{Forward: tt.spec}
+			if got := len(fwd.overrides()); got != tt.want {
+				t.Errorf("overrides() got %d overrides, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarder_checkServer(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major": "1", "minor": "29"}`))
+	})
+	mux.HandleFunc("/unauthorized/version", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	mux.HandleFunc("/forbidden/version", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	mux.HandleFunc("/hang/version", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	closed := httptest.NewServer(mux)
+	closed.Close()
+	timeout := 100 * time.Millisecond
+	tests := []struct {
+		name    string
+		host    string
+		wantErr bool
+	}{
+		{"reachable", srv.URL + "/ok", false},
+		{"forbidden", srv.URL + "/forbidden", false},
+		{"unauthorized", srv.URL + "/unauthorized", true},
+		{"hanging", srv.URL + "/hang", true},
+		{"unreachable", closed.URL, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &rest.Config{Host: tt.host}
+			cs, err := kubernetes.NewForConfig(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fwd := &Forwarder{Forward: config.Forward{Timeout: &timeout}, Clients: cs}
+			if err := fwd.checkServer(rc); (err != nil) != tt.wantErr {
+				t.Errorf("checkServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForwarder_newClients(t *testing.T) {
+	rc := &rest.Config{Host: "https://127.0.0.1:6443", Timeout: 30 * time.Second}
+	fwd := &Forwarder{}
+	if err := fwd.newClients(&kubeclient.Kubeclient{}, rc); err != nil {
+		t.Fatal(err)
+	}
+	timeout := func(cs kubernetes.Interface) time.Duration {
+		return cs.CoreV1().RESTClient().(*rest.RESTClient).Client.Timeout
+	}
+	if got := timeout(fwd.Clients); got != 30*time.Second {
+		t.Errorf("newClients() request timeout = %v, want 30s", got)
+	}
+	if got := timeout(fwd.watchClients()); got != 0 {
+		t.Errorf("newClients() watch timeout = %v, want none", got)
+	}
+	if rc.Timeout != 30*time.Second {
+		t.Errorf("newClients() modified the config")
+	}
+}
+
 func TestForwarder_Run(t *testing.T) {
 	type fields struct {
 		Name    string
@@ -0,0 +1,32 @@
+package forwarder
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// lineLogger is an io.Writer that logs every line written to it, e.g. the output of the portforward library.
+type lineLogger struct {
+	mu  sync.Mutex
+	buf []byte
+	log func(line string)
+}
+
+func (ll *lineLogger) Write(p []byte) (int, error) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	ll.buf = append(ll.buf, p...)
+	for {
+		i := bytes.IndexByte(ll.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(ll.buf[:i]), "\r")
+		ll.buf = ll.buf[i+1:]
+		if line != "" {
+			ll.log(line)
+		}
+	}
+	return len(p), nil
+}
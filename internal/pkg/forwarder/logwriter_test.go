@@ -0,0 +1,20 @@
+package forwarder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_lineLogger(t *testing.T) {
+	var got []string
+	ll := &lineLogger{log: func(line string) { got = append(got, line) }}
+	for _, chunk := range []string{"Forwarding from 127.0.0.1:8080 -> 80\nHandling conn", "ection for 8080\r\n", "\npartial"} {
+		if _, err := ll.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"Forwarding from 127.0.0.1:8080 -> 80", "Handling connection for 8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lineLogger logged %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containers returns the containers of the pod ports are looked up in, which is only the configured one if set.
+func (fwd *Forwarder) containers(pod *v1.Pod) ([]v1.Container, error) {
+	if fwd.Container == nil {
+		return pod.Spec.Containers, nil
+	}
+	var names []string
+	for _, cont := range pod.Spec.Containers {
+		if cont.Name == *fwd.Container {
+			return []v1.Container{cont}, nil
+		}
+		names = append(names, cont.Name)
+	}
+	return nil, fmt.Errorf("container %s not found in pod %s (containers: %s)", *fwd.Container, pod.Name, strings.Join(names, ", "))
+}
+
+// lookupPortByName finds the number of a named port. If containers declare the name with different numbers,
+// e.g. an application and its sidecar, the name is ambiguous and the container has to be chosen explicitly.
+func lookupPortByName(pod *v1.Pod, containers []v1.Container, name string) (int32, error) {
+	var port int32
+	var matches []string
+	for _, cont := range containers {
+		for _, portSpec := range cont.Ports {
+			if portSpec.Name != name {
+				continue
+			}
+			if len(matches) == 0 || portSpec.ContainerPort != port {
+				matches = append(matches, fmt.Sprintf("%s:%d", cont.Name, portSpec.ContainerPort))
+			}
+			port = portSpec.ContainerPort
+		}
+	}
+	switch len(matches) {
+	case 0:
+		if len(containers) == 1 && len(pod.Spec.Containers) > 1 {
+			return 0, fmt.Errorf("port %s not found in container %s of pod %s", name, containers[0].Name, pod.Name)
+		}
+		return 0, fmt.Errorf("port %s not found in pod %s", name, pod.Name)
+	case 1:
+		return port, nil
+	default:
+		return 0, fmt.Errorf("port %s is ambiguous in pod %s (%s), set container to choose one", name, pod.Name, strings.Join(matches, ", "))
+	}
+}
+
+// containerPort resolves the remote port of the forward, either a number or a port name, in the pod.
+func (fwd *Forwarder) containerPort(pod *v1.Pod, remote string) (int32, error) {
+	if port, ok, err := portNumber(remote); ok {
+		return port, err
+	}
+	containers, err := fwd.containers(pod)
+	if err != nil {
+		return 0, err
+	}
+	return lookupPortByName(pod, containers, remote)
+}
+
+// servicePortTarget resolves the container port a service port is routed to in the pod, mapping named targetPorts per pod.
+func (fwd *Forwarder) servicePortTarget(service *v1.Service, pod *v1.Pod, svcPort int32) (int32, error) {
+	portSpec, err := servicePort(service, svcPort)
+	if err != nil {
+		return 0, err
+	}
+	if portSpec.TargetPort.Type == intstr.String && portSpec.TargetPort.StrVal != "" {
+		return fwd.containerPort(pod, portSpec.TargetPort.StrVal)
+	}
+	if portSpec.TargetPort.IntValue() == 0 {
+		return svcPort, nil
+	}
+	return int32(portSpec.TargetPort.IntValue()), nil
+}
+
+// checkPort rejects ports that are declared with a protocol other than TCP in the relevant containers
+// and reports whether the port is declared at all.
+func (fwd *Forwarder) checkPort(pod *v1.Pod, port int32) (bool, error) {
+	containers, err := fwd.containers(pod)
+	if err != nil {
+		return false, err
+	}
+	declared := false
+	for _, cont := range containers {
+		for _, portSpec := range cont.Ports {
+			if portSpec.ContainerPort != port {
+				continue
+			}
+			if portSpec.Protocol != v1.ProtocolTCP && portSpec.Protocol != "" {
+				return false, fmt.Errorf("unsupported protocol: %s", portSpec.Protocol)
+			}
+			declared = true
+		}
+	}
+	return declared, nil
+}
+
+func (fwd *Forwarder) undeclaredPolicy() string {
+	if fwd.Undeclared != nil {
+		return *fwd.Undeclared
+	}
+	return config.UndeclaredWarn
+}
+
+// WarnUndeclared reports whether the user should be warned about forwarding to an undeclared port.
+func (fwd *Forwarder) WarnUndeclared() bool {
+	return !fwd.Declared && fwd.undeclaredPolicy() == config.UndeclaredWarn
+}
+
+// probeAddr returns the address Probe connects to. If connections are proxied, the port-forward session is used directly,
+// so the probe is neither counted in the metrics nor balanced.
+func (fwd *Forwarder) probeAddr() string {
+	if fwd.balancer != nil {
+		if be := fwd.balancer.current(); be != nil {
+			return be.addr
+		}
+	}
+	if fwd.session != "" {
+		return fwd.session
+	}
+	return net.JoinHostPort(fwd.BindAddr, strconv.Itoa(int(fwd.BindPort)))
+}
+
+// Probe connects to the forward to find out whether anything listens on the remote port. The port-forward closes
+// connections right away if the pod refuses them, while listening applications either respond or wait for the client.
+func (fwd *Forwarder) Probe() (bool, error) {
+	conn, err := net.DialTimeout("tcp", fwd.probeAddr(), probeTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(probeTimeout)); err != nil {
+		return false, err
+	}
+	n, err := conn.Read(make([]byte, 1))
+	var ne net.Error
+	listening := n > 0 || (errors.As(err, &ne) && ne.Timeout())
+	fwd.Listening = &listening
+	return listening, nil
+}
+
+// Status describes the forward like String and adds how pods are selected and findings about undeclared remote ports,
+// e.g. for the ready message.
+func (fwd *Forwarder) Status() string {
+	status := fwd.String()
+	switch {
+	case fwd.balancer != nil && fwd.Balance != nil:
+		status = fmt.Sprintf("%s, %s across %d pods", status, fwd.balancer.strategy, len(fwd.balancer.pods()))
+	case fwd.balancer != nil:
+		status = fmt.Sprintf("%s, following rollouts", status)
+	}
+	if fwd.Declared {
+		return status
+	}
+	switch {
+	case fwd.Listening == nil:
+		return fmt.Sprintf("%s, undeclared port", status)
+	case *fwd.Listening:
+		return fmt.Sprintf("%s, undeclared port, listening", status)
+	default:
+		return fmt.Sprintf("%s, undeclared port, nothing listening", status)
+	}
+}
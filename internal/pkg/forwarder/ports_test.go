@@ -0,0 +1,220 @@
+package forwarder
+
+import (
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"github.com/tmsmr/k4wd/internal/pkg/metrics"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sidecarPod has an application and a sidecar container that both declare a port named http.
+func sidecarPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+		Spec: v1.PodSpec{Containers: []v1.Container{
+			{Name: "app", Ports: []v1.ContainerPort{
+				{Name: "http", ContainerPort: 8080, Protocol: v1.ProtocolTCP},
+				{Name: "dns", ContainerPort: 53, Protocol: v1.ProtocolUDP},
+			}},
+			{Name: "proxy", Ports: []v1.ContainerPort{
+				{Name: "http", ContainerPort: 15001, Protocol: v1.ProtocolTCP},
+				{Name: "admin", ContainerPort: 15000, Protocol: v1.ProtocolTCP},
+				{Name: "dns", ContainerPort: 53, Protocol: v1.ProtocolTCP},
+			}},
+		}},
+	}
+}
+
+func TestForwarder_containerPort(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name      string
+		container *string
+		remote    string
+		want      int32
+		wantErr   bool
+	}{
+		{"number", nil, "9090", 9090, false},
+		{"invalid number", nil, "70000", 0, true},
+		{"unique name", nil, "admin", 15000, false},
+		{"ambiguous name", nil, "http", 0, true},
+		{"name in container", str("app"), "http", 8080, false},
+		{"name in other container", str("proxy"), "http", 15001, false},
+		{"name not in container", str("app"), "admin", 0, true},
+		{"unknown name", nil, "grpc", 0, true},
+		{"unknown container", str("worker"), "http", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &Forwarder{Forward: config.Forward{Container: tt.container}}
+			got, err := fwd.containerPort(sidecarPod(), tt.remote)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("containerPort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("containerPort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarder_servicePortTarget(t *testing.T) {
+	str := func(s string) *string { return &s }
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc"},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{
+			{Port: 80, TargetPort: intstr.FromString("http")},
+			{Port: 8443, TargetPort: intstr.FromInt32(443)},
+			{Port: 9000},
+		}},
+	}
+	tests := []struct {
+		name      string
+		container *string
+		svcPort   int32
+		want      int32
+		wantErr   bool
+	}{
+		{"ambiguous named target", nil, 80, 0, true},
+		{"named target in container", str("app"), 80, 8080, false},
+		{"numeric target", nil, 8443, 443, false},
+		{"no target", nil, 9000, 9000, false},
+		{"unknown port", nil, 81, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &Forwarder{Forward: config.Forward{Container: tt.container}}
+			got, err := fwd.servicePortTarget(service, sidecarPod(), tt.svcPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("servicePortTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("servicePortTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarder_checkPort(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name         string
+		container    *string
+		port         int32
+		wantDeclared bool
+		wantErr      bool
+	}{
+		{"tcp", nil, 8080, true, false},
+		{"udp in any container", nil, 53, false, true},
+		{"udp in other container", str("proxy"), 53, true, false},
+		{"udp in container", str("app"), 53, false, true},
+		{"undeclared", nil, 9090, false, false},
+		{"declared in other container", str("app"), 15000, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &Forwarder{Forward: config.Forward{Container: tt.container}}
+			declared, err := fwd.checkPort(sidecarPod(), tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if declared != tt.wantDeclared {
+				t.Errorf("checkPort() = %v, want %v", declared, tt.wantDeclared)
+			}
+		})
+	}
+}
+
+func TestForwarder_Probe(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(conn net.Conn)
+		want   bool
+		status string
+	}{
+		{"waiting for client", func(conn net.Conn) { time.Sleep(2 * probeTimeout); conn.Close() }, true, "undeclared port, listening"},
+		{"responding", func(conn net.Conn) { conn.Write([]byte("hello")); conn.Close() }, true, "undeclared port, listening"},
+		{"refused by pod", func(conn net.Conn) { conn.Close() }, false, "undeclared port, nothing listening"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err == nil {
+					tt.handle(conn)
+				}
+			}()
+			fwd := &Forwarder{BindAddr: "127.0.0.1", BindPort: int32(l.Addr().(*net.TCPAddr).Port)}
+			got, err := fwd.Probe()
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Probe() = %v, want %v", got, tt.want)
+			}
+			if !strings.HasSuffix(fwd.Status(), tt.status) {
+				t.Errorf("Status() = %v, want suffix %v", fwd.Status(), tt.status)
+			}
+		})
+	}
+}
+
+func TestForwarder_Probe_proxied(t *testing.T) {
+	b := newBalancer(config.BalanceRoundRobin)
+	b.add(&backend{pod: "a", addr: mockPodServer(t, "a"), stop: make(chan struct{})})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m := metrics.New()
+	fwd := &Forwarder{Name: "test", Log: discardLog(), Metrics: m.Forward("test"), balancer: b}
+	fwd.BindAddr, fwd.BindPort = "127.0.0.1", int32(l.Addr().(*net.TCPAddr).Port)
+	go fwd.serve(l, b)
+	listening, err := fwd.Probe()
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	if !listening {
+		t.Errorf("Probe() = false, want true")
+	}
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", metrics.Path, nil))
+	if strings.Contains(rec.Body.String(), `k4wd_connections_total{forward="test"} 1`) {
+		t.Errorf("Probe() connection was counted in the metrics")
+	}
+}
+
+func TestForwarder_WarnUndeclared(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		policy   *string
+		declared bool
+		want     bool
+	}{
+		{"declared", nil, true, false},
+		{"default policy", nil, false, true},
+		{"warn", str(config.UndeclaredWarn), false, true},
+		{"allow", str(config.UndeclaredAllow), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &Forwarder{Forward: config.Forward{Undeclared: tt.policy}, Declared: tt.declared}
+			if got := fwd.WarnUndeclared(); got != tt.want {
+				t.Errorf("WarnUndeclared() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
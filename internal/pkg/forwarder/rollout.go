@@ -0,0 +1,148 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	deploymentutil "k8s.io/kubectl/pkg/util/deployment"
+)
+
+// podCache holds the pods of a deployment and the ReplicaSets owning them, or the pods of a service,
+// the service itself and its EndpointSlices, kept up to date by informers.
+type podCache struct {
+	selector       labels.Selector
+	pods           corelisters.PodLister
+	replicaSets    appslisters.ReplicaSetLister
+	services       corelisters.ServiceLister
+	endpointSlices discoverylisters.EndpointSliceLister
+}
+
+// revisions maps the names of the ReplicaSets to their deployment revision.
+func revisions(rss []*appsv1.ReplicaSet) map[string]int64 {
+	revisions := make(map[string]int64)
+	for _, rs := range rss {
+		rev, err := deploymentutil.Revision(rs)
+		if err != nil {
+			continue
+		}
+		revisions[rs.Name] = rev
+	}
+	return revisions
+}
+
+// podRevision returns the deployment revision of the ReplicaSet owning the pod, 0 if unknown.
+func podRevision(pod *v1.Pod, revisions map[string]int64) int64 {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return 0
+	}
+	return revisions[owner.Name]
+}
+
+// followTargets selects the pod a forward that is not balanced uses: a single ready pod of the newest revision.
+// The current pod is kept as long as it belongs to that revision, so connections only migrate on rollouts.
+func followTargets(targets []target, current []string) []target {
+	if len(targets) == 0 {
+		return nil
+	}
+	newest := targets[0].revision
+	for _, t := range targets[1:] {
+		if t.revision > newest {
+			newest = t.revision
+		}
+	}
+	var candidates []target
+	for _, t := range targets {
+		if t.revision == newest {
+			candidates = append(candidates, t)
+		}
+	}
+	for _, t := range candidates {
+		for _, pod := range current {
+			if t.pod == pod {
+				return []target{t}
+			}
+		}
+	}
+	return candidates[:1]
+}
+
+// watchPods caches the pods matching the selector and their ReplicaSets and notifies changed whenever one of them
+// is added, updated or deleted, until stop is closed.
+func (fwd *Forwarder) watchPods(selector labels.Selector, changed chan<- struct{}, stop <-chan struct{}) (*podCache, error) {
+	factory := fwd.informerFactory(func(opts *metav1.ListOptions) {
+		opts.LabelSelector = selector.String()
+	})
+	pods := factory.Core().V1().Pods()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	if err := startInformers(changed, stop, []informers.SharedInformerFactory{factory}, pods.Informer(), replicaSets.Informer()); err != nil {
+		return nil, err
+	}
+	return &podCache{selector: selector, pods: pods.Lister(), replicaSets: replicaSets.Lister()}, nil
+}
+
+// informerFactory creates an informer factory for the namespace of the forward, restricted by tweak.
+func (fwd *Forwarder) informerFactory(tweak func(opts *metav1.ListOptions)) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(fwd.watchClients(), 0,
+		informers.WithNamespace(fwd.Namespace),
+		informers.WithTweakListOptions(tweak),
+	)
+}
+
+// startInformers notifies changed whenever an object of the informers is added, updated or deleted,
+// starts the factories and waits until the caches are synced.
+func startInformers(changed chan<- struct{}, stop <-chan struct{}, factories []informers.SharedInformerFactory, infs ...cache.SharedIndexInformer) error {
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { notify() },
+		UpdateFunc: func(old, obj interface{}) { notify() },
+		DeleteFunc: func(obj interface{}) { notify() },
+	}
+	for _, informer := range infs {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return err
+		}
+	}
+	for _, factory := range factories {
+		factory.Start(stop)
+		for typ, synced := range factory.WaitForCacheSync(stop) {
+			if !synced {
+				return fmt.Errorf("failed to sync informer for %v", typ)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAccess lists the pods and ReplicaSets of the deployment once, so missing permissions are reported right away
+// instead of the informers retrying forever.
+func (fwd *Forwarder) checkAccess(selector labels.Selector) error {
+	opts := metav1.ListOptions{LabelSelector: selector.String(), Limit: 1}
+	if _, err := fwd.Clients.CoreV1().Pods(fwd.Namespace).List(context.TODO(), opts); err != nil {
+		return err
+	}
+	_, err := fwd.Clients.AppsV1().ReplicaSets(fwd.Namespace).List(context.TODO(), opts)
+	return err
+}
+
+// deploymentSelector returns the selector of the pods of the deployment.
+func (fwd *Forwarder) deploymentSelector() (labels.Selector, error) {
+	deployment, err := fwd.Clients.AppsV1().Deployments(fwd.Namespace).Get(context.TODO(), fwd.Deployment, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels), nil
+}
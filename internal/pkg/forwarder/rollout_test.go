@@ -0,0 +1,180 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	deploymentutil "k8s.io/kubectl/pkg/util/deployment"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func mockReplicaSet(name string, revision int64) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   "k4wd",
+		Labels:      map[string]string{"app": "app"},
+		Annotations: map[string]string{deploymentutil.RevisionAnnotation: strconv.FormatInt(revision, 10)},
+	}}
+}
+
+func ownedBy(pod *v1.Pod, rs string) *v1.Pod {
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: rs, Controller: &controller}}
+	return pod
+}
+
+func Test_followTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []target
+		current []string
+		want    []target
+	}{
+		{"no targets", nil, []string{"a"}, nil},
+		{"first pod", []target{{"a", 80, 1}, {"b", 80, 1}}, nil, []target{{"a", 80, 1}}},
+		{"keep current pod", []target{{"a", 80, 1}, {"b", 80, 1}}, []string{"b"}, []target{{"b", 80, 1}}},
+		{"newest revision", []target{{"a", 80, 1}, {"b", 80, 2}, {"c", 80, 2}}, []string{"a"}, []target{{"b", 80, 2}}},
+		{"keep current pod of newest revision", []target{{"a", 80, 1}, {"b", 80, 2}, {"c", 80, 2}}, []string{"c"}, []target{{"c", 80, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := followTargets(tt.targets, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("followTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarder_watchPods(t *testing.T) {
+	ns := "k4wd"
+	cs := fake.NewSimpleClientset(httpPod("a", 8080))
+	fwd := &Forwarder{Namespace: ns, Clients: cs}
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	pods, err := fwd.watchPods(labels.SelectorFromSet(map[string]string{"app": "app"}), changed, stop)
+	if err != nil {
+		t.Fatalf("watchPods() error = %v", err)
+	}
+	// the initial list notifies as well
+	<-changed
+	pod := httpPod("b", 8080)
+	if _, err := cs.CoreV1().Pods(ns).Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("watchPods() did not notify about new pod")
+	}
+	cached, err := pods.pods.Pods(ns).List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 2 {
+		t.Errorf("watchPods() cached %d pods, want 2", len(cached))
+	}
+}
+
+func TestForwarder_serveProxied_cannotFollow(t *testing.T) {
+	ns := "k4wd"
+	balance := config.BalanceRoundRobin
+	forbidReplicaSets := func(cs *fake.Clientset) {
+		cs.PrependReactor("list", "replicasets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(appsv1.Resource("replicasets"), "", errors.New("forbidden"))
+		})
+	}
+	notReady := mockPod("a", v1.PodRunning, false)
+	tests := []struct {
+		name    string
+		balance *string
+		pod     *v1.Pod
+		react   func(cs *fake.Clientset)
+		want    bool
+	}{
+		{"not ready", nil, notReady, nil, true},
+		{"forbidden", nil, httpPod("a", 8080), forbidReplicaSets, true},
+		{"balanced not ready", &balance, notReady, nil, false},
+		{"balanced forbidden", &balance, httpPod("a", 8080), forbidReplicaSets, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: ns},
+				Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}},
+			}
+			cs := fake.NewSimpleClientset(deployment, tt.pod)
+			if tt.react != nil {
+				tt.react(cs)
+			}
+			fwd := &Forwarder{
+				Forward:   config.Forward{Deployment: "app", Remote: "http", Balance: tt.balance},
+				Namespace: ns,
+				Clients:   cs,
+				Ready:     make(chan struct{}),
+				Log:       discardLog(),
+			}
+			stop := make(chan struct{})
+			defer close(stop)
+			err := fwd.serveProxied(nil, stop)
+			if err == nil {
+				t.Fatalf("serveProxied() error = nil, want error")
+			}
+			if got := errors.Is(err, errCannotFollow); got != tt.want {
+				t.Errorf("serveProxied() error = %v, errCannotFollow %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarder_syncBackends_retarget(t *testing.T) {
+	ns := "k4wd"
+	cs := fake.NewSimpleClientset(
+		mockReplicaSet("app-1", 1),
+		mockReplicaSet("app-2", 2),
+		ownedBy(httpPod("a", 8080), "app-1"),
+		ownedBy(httpPod("b", 9090), "app-2"),
+	)
+	fwd := &Forwarder{
+		Forward:    config.Forward{Deployment: "app", Remote: "http"},
+		Namespace:  ns,
+		Clients:    cs,
+		Retargeted: make(chan struct{}, 1),
+		Log:        discardLog(),
+		TargetPod:  "a",
+		TargetPort: 8080,
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	pods, err := fwd.watchPods(labels.SelectorFromSet(map[string]string{"app": "app"}), make(chan struct{}, 1), stop)
+	if err != nil {
+		t.Fatalf("watchPods() error = %v", err)
+	}
+	fwd.pods = pods
+	b := newBalancer(config.BalanceRoundRobin)
+	b.add(&backend{pod: "a", port: 8080, stop: make(chan struct{})})
+	b.add(&backend{pod: "b", port: 9090, stop: make(chan struct{})})
+	fwd.syncBackends(nil, b)
+	if pod, port := fwd.Target(); pod != "b" || port != 9090 {
+		t.Errorf("Target() = %s:%d, want b:9090", pod, port)
+	}
+	select {
+	case <-fwd.Retargeted:
+	default:
+		t.Errorf("syncBackends() did not notify Retargeted")
+	}
+	if got := b.pods(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("pods() = %v, want [b]", got)
+	}
+}
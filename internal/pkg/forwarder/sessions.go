@@ -0,0 +1,48 @@
+package forwarder
+
+import (
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// sessions maps the local ports of port-forward sessions to the log of their forward and pod,
+// so errors the portforward library reports without context can be attributed.
+var sessions = struct {
+	sync.Mutex
+	logs map[int32]*log.Entry
+}{logs: make(map[int32]*log.Entry)}
+
+// sessionPort matches the local port in errors of the portforward library, e.g. "error creating error stream for port 8080 -> 80".
+var sessionPort = regexp.MustCompile(`port (\d+)`)
+
+// addSession registers the local port of a session and returns a function removing it again.
+func addSession(port int32, entry *log.Entry) func() {
+	sessions.Lock()
+	defer sessions.Unlock()
+	sessions.logs[port] = entry
+	return func() {
+		sessions.Lock()
+		defer sessions.Unlock()
+		if sessions.logs[port] == entry {
+			delete(sessions.logs, port)
+		}
+	}
+}
+
+// HandleError logs an error reported through runtime.HandleError of client-go as a warning, with the fields of the
+// forward and pod if the error names the local port of one of their sessions.
+func HandleError(err error) {
+	entry := log.NewEntry(log.StandardLogger())
+	if m := sessionPort.FindStringSubmatch(err.Error()); m != nil {
+		if port, perr := strconv.Atoi(m[1]); perr == nil {
+			sessions.Lock()
+			if e, ok := sessions.logs[int32(port)]; ok {
+				entry = e
+			}
+			sessions.Unlock()
+		}
+	}
+	entry.Warn(err.Error())
+}
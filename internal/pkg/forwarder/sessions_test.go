@@ -0,0 +1,30 @@
+package forwarder
+
+import (
+	"bytes"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHandleError(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	remove := addSession(34567, log.WithFields(log.Fields{"forward": "db", "pod": "postgres-0"}))
+	HandleError(errors.New("error creating error stream for port 34567 -> 5432: timeout"))
+	remove()
+	HandleError(errors.New("error creating error stream for port 34567 -> 5432: timeout"))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("HandleError() logged %d lines, want 2", len(lines))
+	}
+	if !strings.Contains(lines[0], "forward=db") || !strings.Contains(lines[0], "pod=postgres-0") {
+		t.Errorf("HandleError() logged %q, want fields of the session", lines[0])
+	}
+	if strings.Contains(lines[1], "forward=db") {
+		t.Errorf("HandleError() logged %q after the session was removed", lines[1])
+	}
+}
@@ -0,0 +1,91 @@
+package forwarder
+
+import (
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// timeoutDialer bounds the time it takes to connect and upgrade a port-forward connection.
+type timeoutDialer struct {
+	httpstream.Dialer
+	timeout time.Duration
+}
+
+func (d timeoutDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	type result struct {
+		conn     httpstream.Connection
+		protocol string
+		err      error
+	}
+	res := make(chan result, 1)
+	go func() {
+		conn, protocol, err := d.Dialer.Dial(protocols...)
+		res <- result{conn, protocol, err}
+	}()
+	select {
+	case r := <-res:
+		return r.conn, r.protocol, r.err
+	case <-time.After(d.timeout):
+		// close the connection if it is established after all
+		go func() {
+			if r := <-res; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, "", fmt.Errorf("port-forward connection not established within %s", d.timeout)
+	}
+}
+
+func spdyDialer(rc *rest.Config, u *url.URL) (httpstream.Dialer, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(rc)
+	if err != nil {
+		return nil, err
+	}
+	return spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u), nil
+}
+
+// shouldFallback reports whether a failed WebSocket connection should be retried using SPDY,
+// e.g. because the API server does not support WebSockets for port-forwarding yet.
+func shouldFallback(err error) bool {
+	return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+}
+
+// dialer creates the dialer for the portforward subresource at u according to the configured transport.
+func (fwd *Forwarder) dialer(rc *rest.Config, u *url.URL) (httpstream.Dialer, error) {
+	transport := config.TransportAuto
+	if fwd.Transport != nil && *fwd.Transport != "" {
+		transport = *fwd.Transport
+	}
+	var dialer httpstream.Dialer
+	var err error
+	switch transport {
+	case config.TransportSPDY:
+		dialer, err = spdyDialer(rc, u)
+	case config.TransportWebSocket:
+		dialer, err = portforward.NewSPDYOverWebsocketDialer(u, rc)
+	case config.TransportAuto:
+		var ws, sp httpstream.Dialer
+		ws, err = portforward.NewSPDYOverWebsocketDialer(u, rc)
+		if err != nil {
+			return nil, err
+		}
+		sp, err = spdyDialer(rc, u)
+		if err != nil {
+			return nil, err
+		}
+		dialer = portforward.NewFallbackDialer(ws, sp, shouldFallback)
+	default:
+		return nil, fmt.Errorf("unsupported transport: %s", transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return timeoutDialer{Dialer: dialer, timeout: fwd.dialTimeout()}, nil
+}
@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type mockDialer struct {
+	delay time.Duration
+	err   error
+}
+
+func (d mockDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	time.Sleep(d.delay)
+	return nil, protocols[0], d.err
+}
+
+func Test_timeoutDialer_Dial(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialer  mockDialer
+		wantErr bool
+	}{
+		{"fast", mockDialer{}, false},
+		{"failing", mockDialer{err: fmt.Errorf("failed")}, true},
+		{"slow", mockDialer{delay: time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := timeoutDialer{Dialer: tt.dialer, timeout: 100 * time.Millisecond}
+			if _, _, err := d.Dial("portforward.k8s.io"); (err != nil) != tt.wantErr {
+				t.Errorf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForwarder_dialer(t *testing.T) {
+	rc := &rest.Config{Host: "https://127.0.0.1:6443"}
+	u, err := url.Parse("https://127.0.0.1:6443/api/v1/namespaces/default/pods/pod/portforward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name      string
+		transport *string
+		wantErr   bool
+	}{
+		{"default", nil, false},
+		{"auto", str(config.TransportAuto), false},
+		{"spdy", str(config.TransportSPDY), false},
+		{"websocket", str(config.TransportWebSocket), false},
+		{"invalid", str("http3"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &Forwarder{Forward: config.Forward{Transport: tt.transport}}
+			got, err := fwd.dialer(rc, u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dialer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got == nil {
+				t.Errorf("dialer() returned no dialer")
+			}
+		})
+	}
+}
+
+func Test_shouldFallback(t *testing.T) {
+	if shouldFallback(fmt.Errorf("connection refused")) {
+		t.Errorf("shouldFallback() = true for a generic error")
+	}
+	if !shouldFallback(&httpstream.UpgradeFailureError{Cause: fmt.Errorf("unable to upgrade")}) {
+		t.Errorf("shouldFallback() = false for an upgrade failure")
+	}
+}
@@ -1,7 +1,11 @@
 package forwarder
 
 import (
+	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"sync"
 )
 
 func randomLocalPort() (port int, err error) {
@@ -16,3 +20,33 @@ func randomLocalPort() (port int, err error) {
 	defer l.Close()
 	return l.Addr().(*net.TCPAddr).Port, nil
 }
+
+// portNumber parses a numeric remote port, ok is false if s is not a number but a port name.
+func portNumber(s string) (port int32, ok bool, err error) {
+	val, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false, nil
+	}
+	if val < 1 || val > 65535 {
+		return 0, true, fmt.Errorf("invalid port number: %d", val)
+	}
+	return int32(val), true, nil
+}
+
+// pipe copies data between both connections until both directions are done.
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
@@ -0,0 +1,140 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/kubectl/pkg/util/podutils"
+	"strings"
+	"time"
+)
+
+// waitInterval is the polling interval while the target cannot be watched, e.g. because the deployment does not exist yet.
+var waitInterval = time.Second
+
+// errNotReady marks conditions that resolve by themselves once the target is rolled out.
+var errNotReady = errors.New("target not ready")
+
+// retryable reports whether waiting for the target may resolve err, e.g. because it is still being created.
+func retryable(err error) bool {
+	return errors.Is(err, errNotReady) || apierrors.IsNotFound(err) || wait.Interrupted(err)
+}
+
+// podReady checks the phase and readiness conditions of the pod.
+func podReady(pod *v1.Pod) error {
+	if pod.DeletionTimestamp != nil {
+		return fmt.Errorf("%w: pod %s is terminating", errNotReady, pod.Name)
+	}
+	if pod.Status.Phase != v1.PodRunning {
+		return fmt.Errorf("%w: pod %s is %s", errNotReady, pod.Name, strings.ToLower(string(pod.Status.Phase)))
+	}
+	if !podutils.IsPodReady(pod) {
+		return fmt.Errorf("%w: pod %s is running but not ready", errNotReady, pod.Name)
+	}
+	return nil
+}
+
+// watchTarget watches the objects the readiness of the target depends on: the pod, the pods of the deployment
+// or the EndpointSlices of the service.
+func (fwd *Forwarder) watchTarget() (watch.Interface, error) {
+	switch fwd.Type() {
+	case config.ForwardTypePod:
+		opts := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", fwd.Pod).String()}
+		return fwd.watchClients().CoreV1().Pods(fwd.Namespace).Watch(context.TODO(), opts)
+	case config.ForwardTypeDeployment:
+		selector, err := fwd.deploymentSelector()
+		if err != nil {
+			return nil, err
+		}
+		return fwd.watchClients().CoreV1().Pods(fwd.Namespace).Watch(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	case config.ForwardTypeService:
+		opts := metav1.ListOptions{LabelSelector: sliceSelector(fwd.Service).String()}
+		return fwd.watchClients().DiscoveryV1().EndpointSlices(fwd.Namespace).Watch(context.TODO(), opts)
+	default:
+		return nil, fmt.Errorf("unsupported forward type: %d", fwd.Type())
+	}
+}
+
+// awaitTarget resolves the target pod and port. If waiting is enabled, it retries until the pod is ready,
+// the wait timeout expires or the forward is stopped. Otherwise the pod only has to be running.
+// The target is resolved again whenever it changes, it is only polled while it cannot be watched.
+func (fwd *Forwarder) awaitTarget(stop chan struct{}) (*v1.Pod, int32, error) {
+	if fwd.Wait == nil || *fwd.Wait == 0 {
+		pod, port, err := fwd.resolveTarget(false)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pod.Status.Phase != v1.PodRunning {
+			return nil, 0, fmt.Errorf("target pod not running: %s", pod.Name)
+		}
+		return pod, port, nil
+	}
+
+	attempt := func() (*v1.Pod, int32, error) {
+		pod, port, err := fwd.resolveTarget(true)
+		if err != nil {
+			return nil, 0, err
+		}
+		return pod, port, podReady(pod)
+	}
+
+	var w watch.Interface
+	defer func() {
+		if w != nil {
+			w.Stop()
+		}
+	}()
+	started := time.Now()
+	deadline := time.After(*fwd.Wait)
+	last := ""
+	for {
+		// the watch is established before resolving, so no change in between is missed
+		if w == nil {
+			var err error
+			if w, err = fwd.watchTarget(); err != nil {
+				fwd.Log.Debugf("polling target, failed to watch it: %v", err)
+				w = nil
+			}
+		}
+		pod, port, err := attempt()
+		if err == nil {
+			if last != "" {
+				fwd.Log.Infof("target pod %s ready after %s", pod.Name, time.Since(started).Round(time.Second))
+			}
+			return pod, port, nil
+		}
+		if !retryable(err) {
+			return nil, 0, err
+		}
+		if msg := err.Error(); msg != last {
+			fwd.Log.Infof("waiting for target (%s left): %v", (*fwd.Wait - time.Since(started)).Round(time.Second), err)
+			last = msg
+		}
+		var changed <-chan watch.Event
+		var poll <-chan time.Time
+		if w != nil {
+			changed = w.ResultChan()
+		} else {
+			poll = time.After(waitInterval)
+		}
+		select {
+		case <-stop:
+			return nil, 0, fmt.Errorf("stopped while waiting for target: %v", err)
+		case <-deadline:
+			return nil, 0, fmt.Errorf("target not ready after %s: %v", *fwd.Wait, err)
+		case _, ok := <-changed:
+			if !ok {
+				// watches end after a while, the next attempt establishes a new one
+				w = nil
+			}
+		case <-poll:
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package forwarder
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func mockPod(name string, phase v1.PodPhase, ready bool) *v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "k4wd", Labels: map[string]string{"app": "app"}},
+		Status: v1.PodStatus{
+			Phase:      phase,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+		},
+	}
+}
+
+func mockService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "k4wd"},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"app": "app"},
+			Ports:    []v1.ServicePort{{Port: 8080}},
+		},
+	}
+}
+
+func mockEndpointSlice(service string, pods ...string) *discoveryv1.EndpointSlice {
+	var endpoints []discoveryv1.Endpoint
+	for _, pod := range pods {
+		endpoints = append(endpoints, discoveryv1.Endpoint{
+			Addresses: []string{"10.0.0.1"},
+			TargetRef: &v1.ObjectReference{Kind: "Pod", Name: pod},
+		})
+	}
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-abcde",
+			Namespace: "k4wd",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+}
+
+func mockDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "k4wd"},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}},
+	}
+}
+
+func discardLog() *log.Entry {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	return log.NewEntry(logger)
+}
+
+func TestForwarder_awaitTarget(t *testing.T) {
+	ns := "k4wd"
+	dur := func(d time.Duration) *time.Duration { return &d }
+	tests := []struct {
+		name    string
+		forward config.Forward
+		objects []runtime.Object
+		// update is applied to the clientset after the first attempt
+		update  []runtime.Object
+		wantErr bool
+	}{
+		{"running", config.Forward{Pod: "pod", Remote: "8080"}, []runtime.Object{mockPod("pod", v1.PodRunning, false)}, nil, false},
+		{"pending without wait", config.Forward{Pod: "pod", Remote: "8080"}, []runtime.Object{mockPod("pod", v1.PodPending, false)}, nil, true},
+		{"missing without wait", config.Forward{Pod: "pod", Remote: "8080"}, nil, nil, true},
+		{"pending until ready", config.Forward{Pod: "pod", Remote: "8080", Wait: dur(time.Minute)}, []runtime.Object{mockPod("pod", v1.PodPending, false)}, []runtime.Object{mockPod("pod", v1.PodRunning, true)}, false},
+		{"missing until created", config.Forward{Pod: "pod", Remote: "8080", Wait: dur(time.Minute)}, nil, []runtime.Object{mockPod("pod", v1.PodRunning, true)}, false},
+		{"running but not ready", config.Forward{Pod: "pod", Remote: "8080", Wait: dur(100 * time.Millisecond)}, []runtime.Object{mockPod("pod", v1.PodRunning, false)}, nil, true},
+		{"invalid port while waiting", config.Forward{Pod: "pod", Remote: "0", Wait: dur(time.Minute)}, []runtime.Object{mockPod("pod", v1.PodPending, false)}, nil, true},
+		{"service endpoint ready", config.Forward{Service: "svc", Remote: "8080", Wait: dur(time.Minute)}, []runtime.Object{mockService(), mockPod("pod", v1.PodRunning, true), mockEndpointSlice("svc")}, []runtime.Object{mockEndpointSlice("svc", "pod")}, false},
+		{"service without endpoint", config.Forward{Service: "svc", Remote: "8080", Wait: dur(100 * time.Millisecond)}, []runtime.Object{mockService(), mockPod("pod", v1.PodRunning, true), mockEndpointSlice("svc")}, nil, true},
+		{"deployment pod created", config.Forward{Deployment: "app", Remote: "8080", Wait: dur(time.Minute)}, []runtime.Object{mockDeployment()}, []runtime.Object{mockPod("pod", v1.PodRunning, true)}, false},
+		{"deployment without pods", config.Forward{Deployment: "app", Remote: "8080", Wait: dur(100 * time.Millisecond)}, []runtime.Object{mockDeployment()}, nil, true},
+	}
+	prev := waitInterval
+	waitInterval = time.Hour
+	defer func() { waitInterval = prev }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.forward.Namespace = &ns
+			cs := fake.NewSimpleClientset(tt.objects...)
+			fwd := &Forwarder{Forward: tt.forward, Namespace: ns, Clients: cs, Log: discardLog()}
+			go func() {
+				if len(tt.update) == 0 {
+					return
+				}
+				// updates are only seen through the watch, since polling is disabled
+				awaitWatch(cs)
+				for _, obj := range tt.update {
+					if err := cs.Tracker().Update(resource(obj), obj, ns); err != nil {
+						_ = cs.Tracker().Add(obj)
+					}
+				}
+			}()
+			pod, _, err := fwd.awaitTarget(make(chan struct{}))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("awaitTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && pod.Name != "pod" {
+				t.Errorf("awaitTarget() pod = %v, want pod", pod.Name)
+			}
+		})
+	}
+}
+
+func TestForwarder_awaitTarget_stop(t *testing.T) {
+	ns := "k4wd"
+	wait := time.Minute
+	cs := fake.NewSimpleClientset(mockPod("pod", v1.PodPending, false))
+	fwd := &Forwarder{Forward: config.Forward{Pod: "pod", Remote: "8080", Wait: &wait}, Namespace: ns, Clients: cs, Log: discardLog()}
+	stop := make(chan struct{})
+	close(stop)
+	if _, _, err := fwd.awaitTarget(stop); err == nil {
+		t.Errorf("awaitTarget() error = nil, want error after stop")
+	}
+}
+
+func TestForwarder_awaitTarget_stopDeployment(t *testing.T) {
+	ns := "k4wd"
+	wait := time.Minute
+	cs := fake.NewSimpleClientset(mockDeployment())
+	fwd := &Forwarder{Forward: config.Forward{Deployment: "app", Remote: "8080", Wait: &wait}, Namespace: ns, Clients: cs, Log: discardLog()}
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	start := time.Now()
+	if _, _, err := fwd.awaitTarget(stop); err == nil {
+		t.Errorf("awaitTarget() error = nil, want error after stop")
+	}
+	if elapsed := time.Since(start); elapsed >= podBySelectorTimeout {
+		t.Errorf("awaitTarget() returned %s after stop, want right away", elapsed)
+	}
+}
+
+func TestForwarder_awaitTarget_poll(t *testing.T) {
+	ns := "k4wd"
+	wait := time.Minute
+	prev := waitInterval
+	waitInterval = 10 * time.Millisecond
+	defer func() { waitInterval = prev }()
+	cs := fake.NewSimpleClientset(mockPod("pod", v1.PodPending, false))
+	cs.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, errors.New("watch not allowed")
+	})
+	fwd := &Forwarder{Forward: config.Forward{Pod: "pod", Remote: "8080", Wait: &wait}, Namespace: ns, Clients: cs, Log: discardLog()}
+	go func() {
+		_ = cs.Tracker().Update(resource(&v1.Pod{}), mockPod("pod", v1.PodRunning, true), ns)
+	}()
+	if _, _, err := fwd.awaitTarget(make(chan struct{})); err != nil {
+		t.Errorf("awaitTarget() error = %v, want ready pod found by polling", err)
+	}
+}
+
+// awaitWatch blocks until a watch has been started on the clientset.
+func awaitWatch(cs *fake.Clientset) {
+	for {
+		for _, action := range cs.Actions() {
+			if action.GetVerb() == "watch" {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func resource(obj runtime.Object) schema.GroupVersionResource {
+	switch obj.(type) {
+	case *v1.Pod:
+		return v1.SchemeGroupVersion.WithResource("pods")
+	case *discoveryv1.EndpointSlice:
+		return discoveryv1.SchemeGroupVersion.WithResource("endpointslices")
+	default:
+		return v1.SchemeGroupVersion.WithResource("services")
+	}
+}
@@ -1,17 +1,31 @@
 package kubeclient
 
 import (
+	"fmt"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+// InClusterContext is the name of the only context available in in-cluster mode.
+const InClusterContext = "in-cluster"
+
+// serviceAccountDir is where Kubernetes mounts the service account token, CA and namespace into pods.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubeclient provides clients for the contexts of the loaded kubeconfig.
+// Kubeconfig holds the explicit path or the list of merged files, separated like in KUBECONFIG.
+// In in-cluster mode, APIConfig holds a single context using the pod's service account instead.
 type Kubeclient struct {
 	Kubeconfig  string
 	Kubecontext string
+	InCluster   bool
 	APIConfig   *api.Config
 }
 
@@ -23,19 +37,73 @@ func WithKubeconfig(path string) ClientOption {
 	}
 }
 
+// WithInCluster uses the service account of the pod k4wd is running in instead of a kubeconfig.
+func WithInCluster() ClientOption {
+	return func(kc *Kubeclient) {
+		kc.InCluster = true
+	}
+}
+
+// inClusterAPIConfig builds a kubeconfig equivalent to rest.InClusterConfig, so contexts and overrides
+// are handled the same way as for kubeconfig files. The token file is re-read by client-go when rotated.
+func inClusterAPIConfig() (*api.Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+	token := filepath.Join(serviceAccountDir, "token")
+	if _, err := os.Stat(token); err != nil {
+		return nil, fmt.Errorf("not running in a cluster: %v", err)
+	}
+	config := api.NewConfig()
+	config.Clusters[InClusterContext] = &api.Cluster{
+		Server:               "https://" + net.JoinHostPort(host, port),
+		CertificateAuthority: filepath.Join(serviceAccountDir, "ca.crt"),
+	}
+	config.AuthInfos[InClusterContext] = &api.AuthInfo{TokenFile: token}
+	ctx := &api.Context{Cluster: InClusterContext, AuthInfo: InClusterContext}
+	if ns, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		ctx.Namespace = strings.TrimSpace(string(ns))
+	}
+	config.Contexts[InClusterContext] = ctx
+	config.CurrentContext = InClusterContext
+	return config, nil
+}
+
 func New(opts ...ClientOption) (*Kubeclient, error) {
 	kc := &Kubeclient{}
 	for _, opt := range opts {
 		opt(kc)
 	}
+	if kc.InCluster {
+		config, err := inClusterAPIConfig()
+		if err != nil {
+			return nil, err
+		}
+		kc.APIConfig = config
+		return kc, nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kc.Kubeconfig
 	if kc.Kubeconfig == "" {
-		home := os.Getenv("HOME")
-		if home == "" {
-			home = os.Getenv("USERPROFILE")
+		var existing []string
+		for _, p := range rules.GetLoadingPrecedence() {
+			if _, err := os.Stat(p); err == nil {
+				existing = append(existing, p)
+			}
+		}
+		if len(existing) == 0 {
+			// fall back to in-cluster mode when running in a pod without a kubeconfig
+			if config, err := inClusterAPIConfig(); err == nil {
+				kc.InCluster = true
+				kc.APIConfig = config
+				return kc, nil
+			}
+			return nil, fmt.Errorf("no kubeconfig found in %s", strings.Join(rules.GetLoadingPrecedence(), string(filepath.ListSeparator)))
 		}
-		kc.Kubeconfig = filepath.Join(home, ".kube", "config")
+		kc.Kubeconfig = strings.Join(existing, string(filepath.ListSeparator))
 	}
-	config, err := clientcmd.LoadFromFile(kc.Kubeconfig)
+	config, err := rules.Load()
 	if err != nil {
 		return nil, err
 	}
@@ -43,16 +111,116 @@ func New(opts ...ClientOption) (*Kubeclient, error) {
 	return kc, nil
 }
 
-func (kc Kubeclient) RESTConfig(context *string) (*rest.Config, error) {
-	co := clientcmd.ConfigOverrides{}
+type overrides struct {
+	kubeconfig  string
+	qps         float32
+	burst       int
+	timeout     time.Duration
+	dialTimeout time.Duration
+	clientcmd.ConfigOverrides
+}
+
+// ConfigOverride adjusts the configuration returned by RESTConfig, e.g. for a single forward.
+type ConfigOverride func(o *overrides)
+
+// WithKubeconfigFile uses the given kubeconfig file instead of the one(s) loaded by New.
+func WithKubeconfigFile(path string) ConfigOverride {
+	return func(o *overrides) {
+		o.kubeconfig = path
+	}
+}
+
+// WithImpersonation impersonates the given user and groups for all requests.
+func WithImpersonation(user string, groups []string) ConfigOverride {
+	return func(o *overrides) {
+		o.AuthInfo.Impersonate = user
+		o.AuthInfo.ImpersonateGroups = groups
+	}
+}
+
+// WithServer overrides the API server URL of the cluster.
+func WithServer(url string) ConfigOverride {
+	return func(o *overrides) {
+		o.ClusterInfo.Server = url
+	}
+}
+
+// WithProxyURL connects to the API server using the given proxy.
+func WithProxyURL(url string) ConfigOverride {
+	return func(o *overrides) {
+		o.ClusterInfo.ProxyURL = url
+	}
+}
+
+// WithTLSServerName overrides the server name used to verify the certificate of the API server.
+func WithTLSServerName(name string) ConfigOverride {
+	return func(o *overrides) {
+		o.ClusterInfo.TLSServerName = name
+	}
+}
+
+// WithInsecureSkipTLSVerify disables the verification of the certificate of the API server.
+func WithInsecureSkipTLSVerify(insecure bool) ConfigOverride {
+	return func(o *overrides) {
+		o.ClusterInfo.InsecureSkipTLSVerify = insecure
+	}
+}
+
+// WithRateLimits sets the maximum queries per second and burst of queries to the API server.
+func WithRateLimits(qps float32, burst int) ConfigOverride {
+	return func(o *overrides) {
+		o.qps = qps
+		o.burst = burst
+	}
+}
+
+// WithTimeout sets the timeout for requests to the API server.
+func WithTimeout(timeout time.Duration) ConfigOverride {
+	return func(o *overrides) {
+		o.timeout = timeout
+	}
+}
+
+// WithDialTimeout sets the timeout for connecting to the API server.
+func WithDialTimeout(timeout time.Duration) ConfigOverride {
+	return func(o *overrides) {
+		o.dialTimeout = timeout
+	}
+}
+
+func (kc Kubeclient) RESTConfig(context *string, opts ...ConfigOverride) (*rest.Config, error) {
+	o := overrides{}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	if context != nil {
-		co.CurrentContext = *context
+		o.CurrentContext = *context
+	}
+	apiConfig := kc.APIConfig
+	if o.kubeconfig != "" {
+		var err error
+		apiConfig, err = clientcmd.LoadFromFile(o.kubeconfig)
+		if err != nil {
+			return nil, err
+		}
 	}
-	clientConfig := clientcmd.NewDefaultClientConfig(*kc.APIConfig, &co)
+	clientConfig := clientcmd.NewDefaultClientConfig(*apiConfig, &o.ConfigOverrides)
 	restConfig, err := clientConfig.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
+	if o.qps > 0 {
+		restConfig.QPS = o.qps
+	}
+	if o.burst > 0 {
+		restConfig.Burst = o.burst
+	}
+	if o.timeout > 0 {
+		restConfig.Timeout = o.timeout
+	}
+	if o.dialTimeout > 0 {
+		restConfig.Dial = (&net.Dialer{Timeout: o.dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
 	return restConfig, nil
 }
 
@@ -3,11 +3,15 @@ package kubeclient
 import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const kubeconfig = `
@@ -77,6 +81,9 @@ func mockUserKubeconfig(envkey string, unsetkey string) error {
 	if err := os.Unsetenv(unsetkey); err != nil {
 		return err
 	}
+	if err := os.Unsetenv("KUBECONFIG"); err != nil {
+		return err
+	}
 	err = os.Mkdir(path.Join(base, ".kube"), 0755)
 	if err != nil {
 		return err
@@ -84,6 +91,8 @@ func mockUserKubeconfig(envkey string, unsetkey string) error {
 	if err := os.WriteFile(path.Join(base, ".kube", "config"), []byte(kubeconfig), 0644); err != nil {
 		return err
 	}
+	// client-go resolves the home directory once on startup
+	clientcmd.RecommendedHomeFile = path.Join(base, ".kube", "config")
 	return nil
 }
 
@@ -107,6 +116,118 @@ func TestNewDefaultLocationWin(t *testing.T) {
 	}
 }
 
+func TestNewKubeconfigEnv(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := path.Join(dir, "first")
+	second := path.Join(dir, "second")
+	if err := os.WriteFile(first, []byte(kubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := strings.NewReplacer("name: local", "name: other", "cluster: local", "cluster: other", "current-context: local", "current-context: other").Replace(kubeconfig)
+	if err := os.WriteFile(second, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := path.Join(dir, "missing")
+	t.Setenv("KUBECONFIG", strings.Join([]string{first, missing, second}, string(filepath.ListSeparator)))
+	kc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join([]string{first, second}, string(filepath.ListSeparator)); kc.Kubeconfig != want {
+		t.Errorf("New() Kubeconfig = %v, want %v", kc.Kubeconfig, want)
+	}
+	if kc.APIConfig.CurrentContext != "local" {
+		t.Errorf("New() CurrentContext = %v, want local", kc.APIConfig.CurrentContext)
+	}
+	for _, ctx := range []string{"local", "other"} {
+		if _, ok := kc.APIConfig.Contexts[ctx]; !ok {
+			t.Errorf("New() missing merged context %s", ctx)
+		}
+	}
+	t.Setenv("KUBECONFIG", missing)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := New(); err == nil {
+		t.Errorf("New() error = nil, want error for missing kubeconfig")
+	}
+}
+
+func mockServiceAccount(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := clientcmd.Load([]byte(kubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"token":     []byte("token"),
+		"ca.crt":    conf.Clusters["local"].CertificateAuthorityData,
+		"namespace": []byte("k4wd\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(path.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prev := serviceAccountDir
+	serviceAccountDir = dir
+	t.Cleanup(func() { serviceAccountDir = prev })
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+}
+
+func TestNewInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := New(WithInCluster()); err == nil {
+		t.Errorf("New() error = nil, want error outside of a cluster")
+	}
+	mockServiceAccount(t)
+	kc, err := New(WithInCluster())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kc.InCluster || kc.APIConfig.CurrentContext != InClusterContext {
+		t.Errorf("New() InCluster = %v, CurrentContext = %v", kc.InCluster, kc.APIConfig.CurrentContext)
+	}
+	if ns := kc.APIConfig.Contexts[InClusterContext].Namespace; ns != "k4wd" {
+		t.Errorf("New() namespace = %v, want k4wd", ns)
+	}
+	rc, err := kc.RESTConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Host != "https://10.0.0.1:443" || rc.BearerTokenFile != path.Join(serviceAccountDir, "token") {
+		t.Errorf("RESTConfig() Host = %v, BearerTokenFile = %v", rc.Host, rc.BearerTokenFile)
+	}
+	if _, err := kc.Clientset(nil, rc); err != nil {
+		t.Errorf("Clientset() error = %v", err)
+	}
+}
+
+func TestNewInClusterFallback(t *testing.T) {
+	mockServiceAccount(t)
+	home, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("KUBECONFIG", "")
+	prev := clientcmd.RecommendedHomeFile
+	clientcmd.RecommendedHomeFile = path.Join(home, ".kube", "config")
+	t.Cleanup(func() { clientcmd.RecommendedHomeFile = prev })
+	kc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kc.InCluster {
+		t.Errorf("New() InCluster = false, want fallback to in-cluster mode")
+	}
+}
+
 func TestKubeclient_Clientset_RESTConfig(t *testing.T) {
 	dir, err := os.MkdirTemp(os.TempDir(), "")
 	if err != nil {
@@ -157,3 +278,101 @@ func TestKubeclient_Clientset_RESTConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestKubeclient_RESTConfig_Overrides(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "config"), []byte(kubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := strings.NewReplacer("name: local", "name: other", "cluster: local", "cluster: other", "current-context: local", "current-context: other").Replace(kubeconfig)
+	if err := os.WriteFile(path.Join(dir, "other"), []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kc, err := New(WithKubeconfig(path.Join(dir, "config")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := "other"
+	if _, err := kc.RESTConfig(&ctx); err == nil {
+		t.Errorf("RESTConfig() error = nil, want error for context of other kubeconfig")
+	}
+	if _, err := kc.RESTConfig(&ctx, WithKubeconfigFile(path.Join(dir, "other"))); err != nil {
+		t.Errorf("RESTConfig() with kubeconfig file error = %v", err)
+	}
+	if _, err := kc.RESTConfig(nil, WithKubeconfigFile(path.Join(dir, "missing"))); err == nil {
+		t.Errorf("RESTConfig() error = nil, want error for missing kubeconfig file")
+	}
+	rc, err := kc.RESTConfig(nil, WithImpersonation("viewer", []string{"readonly"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Impersonate.UserName != "viewer" || !reflect.DeepEqual(rc.Impersonate.Groups, []string{"readonly"}) {
+		t.Errorf("RESTConfig() Impersonate = %v", rc.Impersonate)
+	}
+}
+
+func TestKubeclient_RESTConfig_ClusterOverrides(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "config"), []byte(kubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kc, err := New(WithKubeconfig(path.Join(dir, "config")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := kc.RESTConfig(nil,
+		WithServer("https://bastion:6443"),
+		WithProxyURL("http://proxy:3128"),
+		WithTLSServerName("kubernetes"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Host != "https://bastion:6443" || rc.TLSClientConfig.ServerName != "kubernetes" || rc.Proxy == nil {
+		t.Errorf("RESTConfig() Host = %v, ServerName = %v, Proxy set = %v", rc.Host, rc.TLSClientConfig.ServerName, rc.Proxy != nil)
+	}
+	rc, err = kc.RESTConfig(nil, WithInsecureSkipTLSVerify(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rc.TLSClientConfig.Insecure || len(rc.TLSClientConfig.CAData) != 0 {
+		t.Errorf("RESTConfig() Insecure = %v, CAData set = %v", rc.TLSClientConfig.Insecure, len(rc.TLSClientConfig.CAData) != 0)
+	}
+	if _, err := kc.RESTConfig(nil, WithProxyURL("ftp://proxy")); err == nil {
+		t.Errorf("RESTConfig() error = nil, want error for unsupported proxy scheme")
+	}
+}
+
+func TestKubeclient_RESTConfig_ClientOverrides(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "config"), []byte(kubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kc, err := New(WithKubeconfig(path.Join(dir, "config")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := kc.RESTConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.QPS != 0 || rc.Burst != 0 || rc.Timeout != 0 || rc.Dial != nil {
+		t.Errorf("RESTConfig() without overrides QPS = %v, Burst = %v, Timeout = %v", rc.QPS, rc.Burst, rc.Timeout)
+	}
+	rc, err = kc.RESTConfig(nil, WithRateLimits(50, 100), WithTimeout(30*time.Second), WithDialTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.QPS != 50 || rc.Burst != 100 || rc.Timeout != 30*time.Second || rc.Dial == nil {
+		t.Errorf("RESTConfig() QPS = %v, Burst = %v, Timeout = %v, Dial set = %v", rc.QPS, rc.Burst, rc.Timeout, rc.Dial != nil)
+	}
+}
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	namespace = "k4wd"
+	// Path is the path metrics are served on.
+	Path = "/metrics"
+)
+
+// Metrics holds the metrics of all forwards, labeled with the name of the forward.
+type Metrics struct {
+	registry    *prometheus.Registry
+	up          *prometheus.GaugeVec
+	ready       *prometheus.GaugeVec
+	connections *prometheus.CounterVec
+	bytesIn     *prometheus.CounterVec
+	bytesOut    *prometheus.CounterVec
+	reconnects  *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	labels := []string{"forward"}
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "forward_up", Help: "Whether the forward is running, including waiting for its target.",
+		}, labels),
+		ready: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "forward_ready", Help: "Whether the forward accepts connections.",
+		}, labels),
+		connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "connections_total", Help: "Connections accepted on the local address.",
+		}, labels),
+		bytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "bytes_in_total", Help: "Bytes received from local clients.",
+		}, labels),
+		bytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "bytes_out_total", Help: "Bytes sent to local clients.",
+		}, labels),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "reconnects_total", Help: "Port-forward sessions to pods that were lost while the forward kept running.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "errors_total", Help: "Errors of the forward, e.g. failed connections to pods or the forward failing.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "connection_duration_seconds", Help: "Duration of connections accepted on the local address.",
+			Buckets: []float64{.01, .1, 1, 10, 60, 300, 1800, 3600},
+		}, labels),
+	}
+	m.registry.MustRegister(m.up, m.ready, m.connections, m.bytesIn, m.bytesOut, m.reconnects, m.errors, m.duration)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves the metrics on Path until the listener is closed.
+func (m *Metrics) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, m.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return srv.Serve(l)
+}
+
+// Forward returns the metrics of a single forward, nil if m is nil. All series of the forward are created right away,
+// so they are reported as 0 until something happens.
+func (m *Metrics) Forward(name string) *Forward {
+	if m == nil {
+		return nil
+	}
+	return &Forward{
+		up:          m.up.WithLabelValues(name),
+		ready:       m.ready.WithLabelValues(name),
+		connections: m.connections.WithLabelValues(name),
+		bytesIn:     m.bytesIn.WithLabelValues(name),
+		bytesOut:    m.bytesOut.WithLabelValues(name),
+		reconnects:  m.reconnects.WithLabelValues(name),
+		errors:      m.errors.WithLabelValues(name),
+		duration:    m.duration.WithLabelValues(name),
+	}
+}
+
+// Forward records the metrics of a single forward. A nil Forward discards everything.
+type Forward struct {
+	up          prometheus.Gauge
+	ready       prometheus.Gauge
+	connections prometheus.Counter
+	bytesIn     prometheus.Counter
+	bytesOut    prometheus.Counter
+	reconnects  prometheus.Counter
+	errors      prometheus.Counter
+	duration    prometheus.Observer
+}
+
+func gauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (f *Forward) SetUp(up bool) {
+	if f != nil {
+		f.up.Set(gauge(up))
+	}
+}
+
+func (f *Forward) SetReady(ready bool) {
+	if f != nil {
+		f.ready.Set(gauge(ready))
+	}
+}
+
+func (f *Forward) Reconnect() {
+	if f != nil {
+		f.reconnects.Inc()
+	}
+}
+
+func (f *Forward) Error() {
+	if f != nil {
+		f.errors.Inc()
+	}
+}
+
+// Conn counts an accepted connection and returns it instrumented, so its traffic and duration are recorded.
+func (f *Forward) Conn(c net.Conn) net.Conn {
+	if f == nil {
+		return c
+	}
+	f.connections.Inc()
+	return &conn{Conn: c, f: f, start: time.Now()}
+}
+
+type conn struct {
+	net.Conn
+	f     *Forward
+	start time.Time
+	once  sync.Once
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.f.bytesIn.Add(float64(n))
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.f.bytesOut.Add(float64(n))
+	return n, err
+}
+
+// CloseWrite half-closes the connection if the underlying connection supports it, like *net.TCPConn.
+func (c *conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() { c.f.duration.Observe(time.Since(c.start).Seconds()) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForward_Conn(t *testing.T) {
+	m := New()
+	f := m.Forward("db")
+	client, server := net.Pipe()
+	c := f.Conn(server)
+	go func() {
+		_, _ = client.Write([]byte("ping"))
+		_, _ = io.ReadAll(client)
+	}()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("pong!")); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	_ = c.Close()
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"connections", testutil.ToFloat64(m.connections), 1},
+		{"bytes in", testutil.ToFloat64(m.bytesIn), 4},
+		{"bytes out", testutil.ToFloat64(m.bytesOut), 5},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(m.duration); n != 1 {
+		t.Errorf("duration series = %d, want 1", n)
+	}
+	if !strings.Contains(scrape(t, m), `k4wd_connection_duration_seconds_count{forward="db"} 1`) {
+		t.Errorf("duration observed more or less than once per connection")
+	}
+}
+
+// scrape returns the metrics in the exposition format.
+func scrape(t *testing.T, m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+	return rec.Body.String()
+}
+
+func TestForward_gauges(t *testing.T) {
+	m := New()
+	f := m.Forward("db")
+	f.SetUp(true)
+	f.SetReady(true)
+	f.SetReady(false)
+	f.Reconnect()
+	f.Error()
+	f.Error()
+	body := scrape(t, m)
+	for _, want := range []string{
+		`k4wd_forward_up{forward="db"} 1`,
+		`k4wd_forward_ready{forward="db"} 0`,
+		`k4wd_reconnects_total{forward="db"} 1`,
+		`k4wd_errors_total{forward="db"} 2`,
+		`k4wd_connections_total{forward="db"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
+
+func TestForward_nil(t *testing.T) {
+	var m *Metrics
+	f := m.Forward("db")
+	f.SetUp(true)
+	f.SetReady(true)
+	f.Reconnect()
+	f.Error()
+	client, server := net.Pipe()
+	defer client.Close()
+	if c := f.Conn(server); c != server {
+		t.Errorf("Conn() wrapped the connection without metrics")
+	}
+}
@@ -0,0 +1,184 @@
+package scaffold
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Candidate is a port of a service or deployment that can be added to a Forwardfile.
+type Candidate struct {
+	Name      string
+	Type      config.ForwardType
+	Target    string
+	Namespace string
+	Remote    string
+	Port      int32
+	// Container is set if the port name is declared by several containers of a deployment
+	Container string
+}
+
+func (c Candidate) String() string {
+	kind := "service"
+	if c.Type == config.ForwardTypeDeployment {
+		kind = "deployment"
+	}
+	if c.Container != "" {
+		return fmt.Sprintf("%s %s/%s port %s of container %s", kind, c.Namespace, c.Target, c.Remote, c.Container)
+	}
+	return fmt.Sprintf("%s %s/%s port %s", kind, c.Namespace, c.Target, c.Remote)
+}
+
+// remote prefers the port name over the number, since names are stable across port changes.
+func remote(name string, port int32) string {
+	if name != "" {
+		return name
+	}
+	return strconv.Itoa(int(port))
+}
+
+var unfriendly = regexp.MustCompile(`[^a-z0-9]+`)
+
+// forwardName derives a name that maps to a readable environment variable, e.g. nginx-http -> NGINX_HTTP_ADDR.
+func forwardName(target, port string, multiple bool) string {
+	name := target
+	if multiple {
+		name = fmt.Sprintf("%s-%s", target, port)
+	}
+	return strings.Trim(unfriendly.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Discover lists the TCP ports of all services and deployments in the namespace.
+// Services without a selector are included, since their endpoints may be managed manually. ExternalName services are not backed by pods.
+func Discover(cs kubernetes.Interface, namespace string) ([]Candidate, error) {
+	var candidates []Candidate
+	services, err := cs.CoreV1().Services(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range services.Items {
+		if svc.Spec.Type == v1.ServiceTypeExternalName {
+			continue
+		}
+		var ports []v1.ServicePort
+		for _, port := range svc.Spec.Ports {
+			if port.Protocol == v1.ProtocolTCP || port.Protocol == "" {
+				ports = append(ports, port)
+			}
+		}
+		for _, port := range ports {
+			r := remote(port.Name, port.Port)
+			candidates = append(candidates, Candidate{
+				Name:      forwardName(svc.Name, r, len(ports) > 1),
+				Type:      config.ForwardTypeService,
+				Target:    svc.Name,
+				Namespace: namespace,
+				Remote:    r,
+				Port:      port.Port,
+			})
+		}
+	}
+	deployments, err := cs.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, deploy := range deployments.Items {
+		type containerPort struct {
+			container string
+			v1.ContainerPort
+		}
+		var ports []containerPort
+		// names declared by several containers are ambiguous, the forward has to choose the container
+		declared := make(map[string]int)
+		for _, cont := range deploy.Spec.Template.Spec.Containers {
+			for _, port := range cont.Ports {
+				if port.Protocol == v1.ProtocolTCP || port.Protocol == "" {
+					ports = append(ports, containerPort{cont.Name, port})
+					declared[port.Name]++
+				}
+			}
+		}
+		for _, port := range ports {
+			r := remote(port.Name, port.ContainerPort.ContainerPort)
+			c := Candidate{
+				Name:      forwardName(deploy.Name, r, len(ports) > 1),
+				Type:      config.ForwardTypeDeployment,
+				Target:    deploy.Name,
+				Namespace: namespace,
+				Remote:    r,
+				Port:      port.ContainerPort.ContainerPort,
+			}
+			if port.Name != "" && declared[port.Name] > 1 {
+				c.Name = forwardName(deploy.Name, fmt.Sprintf("%s-%s", port.container, r), true)
+				c.Container = port.container
+			}
+			candidates = append(candidates, c)
+		}
+	}
+	return uniqueNames(candidates), nil
+}
+
+// uniqueNames ensures no two candidates share a name, e.g. for a service and a deployment with the same name.
+func uniqueNames(candidates []Candidate) []Candidate {
+	res := make([]Candidate, len(candidates))
+	seen := make(map[string]bool)
+	for i, c := range candidates {
+		if seen[c.Name] && c.Type == config.ForwardTypeDeployment {
+			c.Name = fmt.Sprintf("%s-deployment", c.Name)
+		}
+		base := c.Name
+		for n := 2; seen[c.Name]; n++ {
+			c.Name = fmt.Sprintf("%s-%d", base, n)
+		}
+		seen[c.Name] = true
+		res[i] = c
+	}
+	return res
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tableKey(name string) string {
+	if bareKey.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+// Render writes a commented Forwardfile for the candidates, sorted by name. If kubecontext is not nil, it is set for every forward.
+func Render(kubecontext *string, candidates []Candidate) []byte {
+	candidates = append([]Candidate(nil), candidates...)
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	var buf bytes.Buffer
+	buf.WriteString("# Forwardfile generated by k4wd init\n")
+	buf.WriteString("# each forward is exported as <NAME>_ADDR, <NAME>_HOST and <NAME>_PORT when running k4wd env\n")
+	buf.WriteString("# add variables with env templates, e.g. env = { API_URL = \"http://{{.Addr}}\" }\n")
+	for _, c := range candidates {
+		buf.WriteString(fmt.Sprintf("\n[forwards.%s]\n", tableKey(c.Name)))
+		buf.WriteString(fmt.Sprintf("# %s\n", c))
+		if kubecontext != nil {
+			buf.WriteString(fmt.Sprintf("context = %q\n", *kubecontext))
+		}
+		buf.WriteString(fmt.Sprintf("namespace = %q\n", c.Namespace))
+		if c.Type == config.ForwardTypeDeployment {
+			buf.WriteString(fmt.Sprintf("deployment = %q\n", c.Target))
+		} else {
+			buf.WriteString(fmt.Sprintf("service = %q\n", c.Target))
+		}
+		buf.WriteString(fmt.Sprintf("remote = %q\n", c.Remote))
+		if c.Container != "" {
+			buf.WriteString(fmt.Sprintf("container = %q\n", c.Container))
+		}
+		buf.WriteString("# uncomment to use a fixed local port instead of a random one\n")
+		buf.WriteString(fmt.Sprintf("# local = \"%d\"\n", c.Port))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,145 @@
+package scaffold
+
+import (
+	"github.com/tmsmr/k4wd/internal/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mockClientset() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "k4wd"},
+			Spec: v1.ServiceSpec{
+				Selector: map[string]string{"app": "nginx"},
+				Ports:    []v1.ServicePort{{Name: "http-alt", Port: 8080, Protocol: v1.ProtocolTCP}},
+			},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "dns", Namespace: "k4wd"},
+			Spec: v1.ServiceSpec{
+				Selector: map[string]string{"app": "dns"},
+				Ports: []v1.ServicePort{
+					{Name: "dns-udp", Port: 53, Protocol: v1.ProtocolUDP},
+					{Port: 53, Protocol: v1.ProtocolTCP},
+				},
+			},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "external", Namespace: "k4wd"},
+			Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 443}}},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "alias", Namespace: "k4wd"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "example.com", Ports: []v1.ServicePort{{Port: 443}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "k4wd"},
+			Spec: appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{
+				{Name: "nginx", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 80}, {Name: "metrics", ContainerPort: 9090}}},
+			}}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "k4wd"},
+			Spec: appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{
+				{Name: "app", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+				{Name: "proxy", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 15001}}},
+			}}}},
+		},
+	)
+}
+
+func TestDiscover(t *testing.T) {
+	got, err := Discover(mockClientset(), "k4wd")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	want := []Candidate{
+		{Name: "dns", Type: config.ForwardTypeService, Target: "dns", Namespace: "k4wd", Remote: "53", Port: 53},
+		{Name: "external", Type: config.ForwardTypeService, Target: "external", Namespace: "k4wd", Remote: "443", Port: 443},
+		{Name: "nginx", Type: config.ForwardTypeService, Target: "nginx", Namespace: "k4wd", Remote: "http-alt", Port: 8080},
+		{Name: "app-app-http", Type: config.ForwardTypeDeployment, Target: "app", Namespace: "k4wd", Remote: "http", Port: 8080, Container: "app"},
+		{Name: "app-proxy-http", Type: config.ForwardTypeDeployment, Target: "app", Namespace: "k4wd", Remote: "http", Port: 15001, Container: "proxy"},
+		{Name: "nginx-http", Type: config.ForwardTypeDeployment, Target: "nginx", Namespace: "k4wd", Remote: "http", Port: 80},
+		{Name: "nginx-metrics", Type: config.ForwardTypeDeployment, Target: "nginx", Namespace: "k4wd", Remote: "metrics", Port: 9090},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Discover() got = %v, want %v", got, want)
+	}
+}
+
+func Test_forwardName(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		port     string
+		multiple bool
+		want     string
+	}{
+		{"single", "nginx", "http", false, "nginx"},
+		{"multiple", "nginx", "http", true, "nginx-http"},
+		{"unfriendly", "My.App", "8080", true, "my-app-8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardName(tt.target, tt.port, tt.multiple); got != tt.want {
+				t.Errorf("forwardName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_uniqueNames(t *testing.T) {
+	got := uniqueNames([]Candidate{
+		{Name: "nginx", Type: config.ForwardTypeService},
+		{Name: "nginx", Type: config.ForwardTypeDeployment},
+		{Name: "nginx", Type: config.ForwardTypeService},
+	})
+	var names []string
+	for _, c := range got {
+		names = append(names, c.Name)
+	}
+	if want := []string{"nginx", "nginx-deployment", "nginx-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("uniqueNames() got = %v, want %v", names, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	candidates, err := Discover(mockClientset(), "k4wd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubecontext := "local"
+	dir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffpath := path.Join(dir, "Forwardfile")
+	if err := os.WriteFile(ffpath, Render(&kubecontext, candidates), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ff, err := config.Load(config.WithPath(ffpath))
+	if err != nil {
+		t.Fatalf("Render() produced an invalid Forwardfile: %v", err)
+	}
+	if header := string(Render(nil, nil)); !strings.Contains(header, "<NAME>_HOST and <NAME>_PORT") || !strings.Contains(header, "env = {") {
+		t.Errorf("Render() header = %q, want the exported variables and env templates", header)
+	}
+	if len(ff.Forwards) != len(candidates) {
+		t.Errorf("Render() got %d forwards, want %d", len(ff.Forwards), len(candidates))
+	}
+	fwd := ff.Forwards["nginx-http"]
+	if fwd.Deployment != "nginx" || fwd.Remote != "http" || *fwd.Context != kubecontext || *fwd.Namespace != "k4wd" || fwd.Container != nil {
+		t.Errorf("Render() got forward = %v", fwd)
+	}
+	if fwd := ff.Forwards["app-proxy-http"]; fwd.Container == nil || *fwd.Container != "proxy" {
+		t.Errorf("Render() got forward = %v, want container proxy", fwd)
+	}
+}